@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/maintenance"
+)
+
+func init() {
+	initializers.LoadEnvVar()
+	initializers.DatabaseConnection()
+	initializers.LoadConfig()
+	initializers.StorageConnection()
+}
+
+// Removes uploaded files that are no longer referenced in the database.
+//
+//	go run cleanup/cleanup.go -dry-run          list the orphans without deleting them
+//	go run cleanup/cleanup.go -grace 72h        only delete orphans older than 72 hours
+//	go run cleanup/cleanup.go -dry-run -json    print the report as JSON
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report orphaned files, do not delete them")
+	grace := flag.Duration("grace", initializers.AppConfig.UploadCleanupGrace, "keep files modified more recently than this")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	report, err := maintenance.CleanOrphanedUploads(context.Background(), initializers.DB, initializers.Storage, *grace, *dryRun)
+	if err != nil {
+		log.Fatalf("Upload cleanup failed: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		maintenance.LogUploadCleanupReport(report)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371000
+
+type checkpointResponse struct {
+	ID        uint    `json:"id"`
+	Name      string  `json:"name"`
+	Block     string  `json:"block"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Radius    float64 `json:"radius"`
+	IsActive  bool    `json:"is_active"`
+}
+
+func toCheckpointResponse(cp models.Checkpoint) checkpointResponse {
+	return checkpointResponse{
+		ID:        cp.ID,
+		Name:      cp.Name,
+		Block:     cp.Block,
+		Latitude:  cp.Latitude,
+		Longitude: cp.Longitude,
+		Radius:    cp.Radius,
+		IsActive:  cp.Is_Active,
+	}
+}
+
+// distanceMeters returns the great-circle distance between two coordinates (haversine formula)
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func validCoordinates(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// nearestCheckpoint returns the active checkpoint closest to the coordinates and the distance to it in meters.
+// It returns nil when no checkpoint is defined.
+func nearestCheckpoint(lat, lon float64) (*models.Checkpoint, float64, error) {
+	var checkpoints []models.Checkpoint
+	if err := initializers.DB.Where("is_active = ?", true).Find(&checkpoints).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var nearest *models.Checkpoint
+	nearestDistance := math.Inf(1)
+	for i := range checkpoints {
+		d := distanceMeters(lat, lon, checkpoints[i].Latitude, checkpoints[i].Longitude)
+		if d < nearestDistance {
+			nearest = &checkpoints[i]
+			nearestDistance = d
+		}
+	}
+	if nearest == nil {
+		return nil, 0, nil
+	}
+	return nearest, nearestDistance, nil
+}
+
+// applyGeofence matches a new security record to the nearest checkpoint and sets its geofence and accuracy flags.
+// An empty block is filled in from the checkpoint.
+func applyGeofence(record *models.SecurityRecord, lat, lon, accuracy float64) error {
+	record.Accuracy = accuracy
+	record.Low_Accuracy = accuracy > initializers.AppConfig.GPSMaxAccuracy
+
+	checkpoint, distance, err := nearestCheckpoint(lat, lon)
+	if err != nil || checkpoint == nil {
+		return err
+	}
+	record.Checkpoint_Id = &checkpoint.ID
+	record.Distance = math.Round(distance*10) / 10
+	record.Outside_Geofence = distance > checkpoint.Radius
+	if record.Block == "" {
+		record.Block = checkpoint.Block
+	}
+	return nil
+}
+
+// GetCheckpoints lists the patrol checkpoints, ?active=true returns only the active ones
+func GetCheckpoints(c *gin.Context) {
+	if !isAdmin(c) && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var checkpoints []models.Checkpoint
+	db := initializers.DB.Order("block ASC, name ASC")
+	if c.Query("active") == "true" {
+		db = db.Where("is_active = ?", true)
+	}
+	if err := db.Find(&checkpoints).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get checkpoints"})
+		return
+	}
+
+	response := make([]checkpointResponse, len(checkpoints))
+	for i, cp := range checkpoints {
+		response[i] = toCheckpointResponse(cp)
+	}
+	c.JSON(200, gin.H{"data": response})
+}
+
+// GetCheckpointById gets a checkpoint by id
+func GetCheckpointById(c *gin.Context) {
+	if !isAdmin(c) && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&checkpoint).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Checkpoint not found"})
+		return
+	}
+	c.JSON(200, gin.H{"data": toCheckpointResponse(checkpoint)})
+}
+
+// CreateCheckpoint adds a patrol checkpoint
+func CreateCheckpoint(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var body struct {
+		Name      string  `json:"name"`
+		Block     string  `json:"block"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Radius    float64 `json:"radius"`
+		IsActive  *bool   `json:"is_active"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.Name == "" {
+		c.JSON(400, gin.H{"message": "Name is required"})
+		return
+	}
+	if !validCoordinates(body.Latitude, body.Longitude) {
+		c.JSON(400, gin.H{"message": "Invalid coordinates"})
+		return
+	}
+	if body.Radius <= 0 {
+		c.JSON(400, gin.H{"message": "Radius must be greater than 0"})
+		return
+	}
+
+	nonce, err := newCheckpointNonce()
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create checkpoint"})
+		return
+	}
+	checkpoint := models.Checkpoint{
+		Name:        body.Name,
+		Block:       body.Block,
+		Latitude:    body.Latitude,
+		Longitude:   body.Longitude,
+		Radius:      body.Radius,
+		Is_Active:   body.IsActive == nil || *body.IsActive,
+		Token_Nonce: nonce,
+	}
+	if err := initializers.DB.Create(&checkpoint).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create checkpoint"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Checkpoint created successfully",
+		"data":    toCheckpointResponse(checkpoint),
+	})
+}
+
+// UpdateCheckpoint changes the fields present in the request body
+func UpdateCheckpoint(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&checkpoint).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Checkpoint not found"})
+		return
+	}
+
+	var body struct {
+		Name      *string  `json:"name"`
+		Block     *string  `json:"block"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Radius    *float64 `json:"radius"`
+		IsActive  *bool    `json:"is_active"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	if body.Name != nil {
+		if *body.Name == "" {
+			c.JSON(400, gin.H{"message": "Name is required"})
+			return
+		}
+		checkpoint.Name = *body.Name
+	}
+	if body.Block != nil {
+		checkpoint.Block = *body.Block
+	}
+	if body.Latitude != nil {
+		checkpoint.Latitude = *body.Latitude
+	}
+	if body.Longitude != nil {
+		checkpoint.Longitude = *body.Longitude
+	}
+	if !validCoordinates(checkpoint.Latitude, checkpoint.Longitude) {
+		c.JSON(400, gin.H{"message": "Invalid coordinates"})
+		return
+	}
+	if body.Radius != nil {
+		if *body.Radius <= 0 {
+			c.JSON(400, gin.H{"message": "Radius must be greater than 0"})
+			return
+		}
+		checkpoint.Radius = *body.Radius
+	}
+	if body.IsActive != nil {
+		checkpoint.Is_Active = *body.IsActive
+	}
+
+	if err := initializers.DB.Save(&checkpoint).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update checkpoint"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Checkpoint updated",
+		"data":    toCheckpointResponse(checkpoint),
+	})
+}
+
+// DeleteCheckpoint removes a checkpoint, existing security records keep their checkpoint_id
+func DeleteCheckpoint(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&checkpoint).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Checkpoint not found"})
+		return
+	}
+	if err := initializers.DB.Delete(&checkpoint).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete checkpoint"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Checkpoint deleted"})
+}
+
+// checkpointTokenPrefix marks the content of the checkpoint QR codes and NFC tags
+const checkpointTokenPrefix = "SIMALING-CP"
+
+// newCheckpointNonce returns a random value for models.Checkpoint.Token_Nonce
+func newCheckpointNonce() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func checkpointTokenSignature(id uint, nonce string) string {
+	mac := hmac.New(sha256.New, initializers.AppConfig.CheckpointTokenSecret)
+	fmt.Fprintf(mac, "%d:%s", id, nonce)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// checkpointToken is the text encoded in the QR code of a checkpoint: SIMALING-CP:<id>:<nonce>:<signature>
+func checkpointToken(cp models.Checkpoint) string {
+	return fmt.Sprintf("%s:%d:%s:%s", checkpointTokenPrefix, cp.ID, cp.Token_Nonce, checkpointTokenSignature(cp.ID, cp.Token_Nonce))
+}
+
+// parseCheckpointToken checks the signature of a scanned token and returns the checkpoint id and nonce it carries
+func parseCheckpointToken(token string) (uint, string, bool) {
+	parts := strings.Split(strings.TrimSpace(token), ":")
+	if len(parts) != 4 || parts[0] != checkpointTokenPrefix || parts[2] == "" {
+		return 0, "", false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	expected := checkpointTokenSignature(uint(id), parts[2])
+	if !hmac.Equal([]byte(expected), []byte(parts[3])) {
+		return 0, "", false
+	}
+	return uint(id), parts[2], true
+}
+
+// GetCheckpointQR returns the QR code to print for a checkpoint as a PNG image (?size= in pixels, default 512).
+// ?format=text returns the token instead, for writing NFC tags.
+func GetCheckpointQR(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&checkpoint).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Checkpoint not found"})
+		return
+	}
+	// Checkpoints created before QR codes existed get their nonce on first use
+	if checkpoint.Token_Nonce == "" {
+		nonce, err := newCheckpointNonce()
+		if err == nil {
+			checkpoint.Token_Nonce = nonce
+			err = initializers.DB.Model(&checkpoint).Update("token_nonce", nonce).Error
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Failed to create checkpoint code"})
+			return
+		}
+	}
+	token := checkpointToken(checkpoint)
+
+	if c.Query("format") == "text" {
+		c.JSON(200, gin.H{"data": gin.H{"token": token}})
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "512"))
+	if err != nil || size < 128 || size > 2048 {
+		c.JSON(400, gin.H{"message": "Invalid size, use 128 to 2048"})
+		return
+	}
+	png, err := qrcode.Encode(token, qrcode.Medium, size)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create QR code"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="checkpoint-%d.png"`, checkpoint.ID))
+	c.Data(200, "image/png", png)
+}
+
+// RotateCheckpointToken replaces the token of a checkpoint, for example when a code was copied or a tag lost.
+// The printed code stops working and has to be replaced with the one from GetCheckpointQR.
+func RotateCheckpointToken(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&checkpoint).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Checkpoint not found"})
+		return
+	}
+	nonce, err := newCheckpointNonce()
+	if err == nil {
+		checkpoint.Token_Nonce = nonce
+		err = initializers.DB.Model(&checkpoint).Update("token_nonce", nonce).Error
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to rotate checkpoint code"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Checkpoint code rotated, print the new QR code",
+		"data":    gin.H{"token": checkpointToken(checkpoint)},
+	})
+}
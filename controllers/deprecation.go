@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxDeprecatedClientLength matches the size of the client column
+const maxDeprecatedClientLength = 150
+
+// deprecatedClient identifies the app calling a deprecated route
+func deprecatedClient(c *gin.Context) string {
+	client := strings.TrimSpace(c.GetHeader("X-App-Version"))
+	if client == "" {
+		client = strings.TrimSpace(c.GetHeader("User-Agent"))
+	}
+	if client == "" {
+		client = "unknown"
+	}
+	if len(client) > maxDeprecatedClientLength {
+		client = client[:maxDeprecatedClientLength]
+	}
+	return client
+}
+
+// Deprecated marks a route as deprecated: the response carries a Deprecation header and a Link to the
+// successor, and every call is counted per route, user and client in deprecated_route_usages.
+// It must run after Authenticate so calls can be attributed to a user.
+func Deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+
+		userID, _ := currentUserID(c)
+		now := time.Now()
+		usage := models.DeprecatedRouteUsage{
+			Route:         route,
+			User_Id:       userID,
+			Client:        deprecatedClient(c),
+			Calls:         1,
+			First_Used_At: now,
+			Last_Used_At:  now,
+		}
+		// Counting must never break the request, a failure is only logged
+		err := initializers.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "route"}, {Name: "user_id"}, {Name: "client"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"calls":        gorm.Expr("calls + 1"),
+				"last_used_at": now,
+			}),
+		}).Create(&usage).Error
+		if err != nil {
+			log.Printf("Failed to count deprecated route %s: %v", route, err)
+		}
+		c.Next()
+	}
+}
+
+// GetDeprecatedRouteUsage reports who still calls the deprecated routes, per route and client.
+// ?days=N only counts the users and clients seen in the last N days (default 30, 0 for all time).
+func GetDeprecatedRouteUsage(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	days := 30
+	if daysStr := c.Query("days"); daysStr != "" {
+		n, err := strconv.Atoi(daysStr)
+		if err != nil || n < 0 {
+			c.JSON(400, gin.H{"message": "Invalid days"})
+			return
+		}
+		days = n
+	}
+
+	var usages []models.DeprecatedRouteUsage
+	db := initializers.DB.Order("route ASC, last_used_at DESC")
+	if days > 0 {
+		db = db.Where("last_used_at >= ?", time.Now().AddDate(0, 0, -days))
+	}
+	if err := db.Find(&usages).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get deprecated route usage"})
+		return
+	}
+
+	type clientUsage struct {
+		Client     string    `json:"client"`
+		Calls      int64     `json:"calls"`
+		Users      int       `json:"users"`
+		LastUsedAt time.Time `json:"last_used_at"`
+	}
+	type routeUsage struct {
+		Route       string        `json:"route"`
+		Calls       int64         `json:"calls"`
+		Users       int           `json:"users"`
+		FirstUsedAt time.Time     `json:"first_used_at"`
+		LastUsedAt  time.Time     `json:"last_used_at"`
+		Clients     []clientUsage `json:"clients"`
+	}
+
+	routes := map[string]*routeUsage{}
+	routeUsers := map[string]map[uint]bool{}
+	clients := map[string]map[string]*clientUsage{}
+	for _, u := range usages {
+		route := routes[u.Route]
+		if route == nil {
+			route = &routeUsage{Route: u.Route, FirstUsedAt: u.First_Used_At}
+			routes[u.Route] = route
+			routeUsers[u.Route] = map[uint]bool{}
+			clients[u.Route] = map[string]*clientUsage{}
+		}
+		route.Calls += u.Calls
+		routeUsers[u.Route][u.User_Id] = true
+		if u.First_Used_At.Before(route.FirstUsedAt) {
+			route.FirstUsedAt = u.First_Used_At
+		}
+		if u.Last_Used_At.After(route.LastUsedAt) {
+			route.LastUsedAt = u.Last_Used_At
+		}
+
+		client := clients[u.Route][u.Client]
+		if client == nil {
+			client = &clientUsage{Client: u.Client}
+			clients[u.Route][u.Client] = client
+		}
+		client.Calls += u.Calls
+		client.Users++
+		if u.Last_Used_At.After(client.LastUsedAt) {
+			client.LastUsedAt = u.Last_Used_At
+		}
+	}
+
+	response := make([]routeUsage, 0, len(routes))
+	for name, route := range routes {
+		route.Users = len(routeUsers[name])
+		for _, client := range clients[name] {
+			route.Clients = append(route.Clients, *client)
+		}
+		// The clients still calling most recently first
+		sort.Slice(route.Clients, func(i, j int) bool {
+			return route.Clients[i].LastUsedAt.After(route.Clients[j].LastUsedAt)
+		})
+		response = append(response, *route)
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].Route < response[j].Route })
+
+	c.JSON(200, gin.H{"days": days, "data": response})
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/events"
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// eventKeepAlive is how often a comment is sent on an idle stream so proxies do not close it
+	eventKeepAlive = 25 * time.Second
+	// eventTicketLifetime is how long a stream ticket can wait before it is used
+	eventTicketLifetime = time.Minute
+)
+
+// staffAudience reaches the admins and the guards, plus the given users
+func staffAudience(users ...uint) events.Audience {
+	return events.Audience{Roles: []uint{1, 3}, Users: users}
+}
+
+// publishEvent pushes an event to the connected clients, failures are only logged
+func publishEvent(eventType string, data interface{}, audience events.Audience) {
+	err := initializers.Events.Publish(context.Background(), events.Event{Type: eventType, Data: data, Audience: audience})
+	if err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// CreateEventStreamTicket returns a single use ticket opening the event stream as ?ticket=, for EventSource
+// clients that cannot set the Authorization header. Unlike the JWT the ticket is harmless in access logs.
+func CreateEventStreamTicket(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	if !ok {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create stream ticket"})
+		return
+	}
+
+	now := time.Now()
+	// Remove expired tickets so the table does not grow forever
+	initializers.DB.Unscoped().Where("expires_at < ?", now).Delete(&models.EventStreamTicket{})
+
+	ticket := models.EventStreamTicket{
+		Ticket:     hex.EncodeToString(raw),
+		User_Id:    uid,
+		Token:      c.GetString("token"),
+		Expires_At: now.Add(eventTicketLifetime),
+	}
+	if err := initializers.DB.Create(&ticket).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create stream ticket"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "Stream ticket created",
+		"data":    gin.H{"ticket": ticket.Ticket, "expires_at": ticket.Expires_At},
+	})
+}
+
+// EventStreamTicket turns the ?ticket= of an EventSource request into the Authorization header of the
+// token it was created with. The ticket is deleted on first use, an unknown or expired ticket is refused.
+func EventStreamTicket(c *gin.Context) {
+	value := c.Query("ticket")
+	if value == "" || c.GetHeader("Authorization") != "" {
+		c.Next()
+		return
+	}
+	var ticket models.EventStreamTicket
+	err := initializers.DB.Where("ticket = ? AND expires_at > ?", value, time.Now()).First(&ticket).Error
+	if err == nil {
+		// Only the request deleting the row may use it, a replay of the same URL is refused
+		result := initializers.DB.Unscoped().Where("id = ?", ticket.ID).Delete(&models.EventStreamTicket{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			err = gorm.ErrRecordNotFound
+		}
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"message": "Invalid or expired stream ticket"})
+		return
+	}
+	c.Request.Header.Set("Authorization", "Bearer "+ticket.Token)
+	c.Next()
+}
+
+// StreamEvents pushes the events the authenticated user may see as Server-Sent Events.
+// ?types=sos,funds.accepted only sends the events whose type starts with one of the prefixes.
+// The stream ends when the token is logged out.
+func StreamEvents(c *gin.Context) {
+	uid, ok := currentUserID(c)
+	var user models.User
+	if !ok || initializers.DB.First(&user, uid).Error != nil {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+	var prefixes []string
+	for _, prefix := range strings.Split(c.Query("types"), ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	ctx := c.Request.Context()
+	stream, err := initializers.Events.Subscribe(ctx)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to subscribe to events"})
+		return
+	}
+	token := c.GetString("token")
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // nginx would hold the events back otherwise
+	c.Status(200)
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			var count int64
+			initializers.DB.Model(&models.BlacklistToken{}).Where("token = ?", token).Count(&count)
+			if count > 0 {
+				return false
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case e, ok := <-stream:
+			if !ok {
+				return false
+			}
+			if !e.Audience.Includes(user.ID, user.Role_Id, user.Block) || !matchesEventPrefix(e.Type, prefixes) {
+				return true
+			}
+			data, err := json.Marshal(e.Client())
+			if err != nil {
+				return true
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			return true
+		}
+	})
+}
+
+func matchesEventPrefix(eventType string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+)
+
+// jakartaLocation is used for every date written to an export file.
+// Alpine images may ship without tzdata, so fall back to a fixed WIB offset.
+var jakartaLocation = loadJakartaLocation()
+
+func loadJakartaLocation() *time.Location {
+	loc, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		return time.FixedZone("WIB", 7*60*60)
+	}
+	return loc
+}
+
+// exportColumn describes one column of an export file.
+// ID and EN are the localized headers, Value extracts the cell from a scanned row.
+type exportColumn[T any] struct {
+	ID    string
+	EN    string
+	Value func(row T, lang string) interface{}
+}
+
+// exportFormat returns "csv" or "xlsx" when the client asked for a file through the
+// format query parameter or the Accept header, and "" when JSON should be returned.
+func exportFormat(c *gin.Context) string {
+	switch strings.ToLower(c.Query("format")) {
+	case exportCSV:
+		return exportCSV
+	case exportXLSX:
+		return exportXLSX
+	case "json":
+		return ""
+	}
+
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "text/csv"):
+		return exportCSV
+	case strings.Contains(accept, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"):
+		return exportXLSX
+	}
+	return ""
+}
+
+// exportLanguage returns "en" when English headers are requested, Indonesian ("id") otherwise
+func exportLanguage(c *gin.Context) string {
+	lang := c.Query("lang")
+	if lang == "" {
+		lang = c.GetHeader("Accept-Language")
+	}
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(lang)), "en") {
+		return "en"
+	}
+	return "id"
+}
+
+// localize picks the Indonesian or English text
+func localize(lang, id, en string) string {
+	if lang == "en" {
+		return en
+	}
+	return id
+}
+
+// yesNo renders a boolean column
+func yesNo(v bool, lang string) string {
+	if v {
+		return localize(lang, "Ya", "Yes")
+	}
+	return localize(lang, "Tidak", "No")
+}
+
+// exportTime formats a timestamp in Jakarta time
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(jakartaLocation).Format("2006-01-02 15:04:05")
+}
+
+// exportOptionalFloat renders a nullable number, nil leaves the cell empty
+func exportOptionalFloat(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// streamExport writes every row matched by query as a CSV or XLSX attachment.
+// The query must not be paginated, rows are read one by one so large tables are not loaded in memory.
+func streamExport[T any](c *gin.Context, format, name string, query *gorm.DB, columns []exportColumn[T]) {
+	rows, err := query.Rows()
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to export data"})
+		return
+	}
+	defer rows.Close()
+
+	lang := exportLanguage(c)
+	headers := make([]string, len(columns))
+	for i, col := range columns {
+		headers[i] = localize(lang, col.ID, col.EN)
+	}
+	filename := name + "-" + time.Now().In(jakartaLocation).Format("20060102-150405") + "." + format
+
+	switch format {
+	case exportCSV:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(200)
+
+		// Byte order mark so spreadsheet applications detect UTF-8
+		c.Writer.WriteString("\xEF\xBB\xBF")
+		w := csv.NewWriter(c.Writer)
+		w.Write(headers)
+
+		record := make([]string, len(columns))
+		for n := 1; rows.Next(); n++ {
+			var row T
+			if err := initializers.DB.ScanRows(rows, &row); err != nil {
+				abortExport(c, name, err)
+				return
+			}
+			for i, col := range columns {
+				record[i] = csvValue(col.Value(row, lang))
+			}
+			w.Write(record)
+			if n%500 == 0 {
+				w.Flush()
+				if err := w.Error(); err != nil {
+					abortExport(c, name, err)
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+		if err := rows.Err(); err != nil {
+			abortExport(c, name, err)
+			return
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			abortExport(c, name, err)
+		}
+
+	case exportXLSX:
+		f := excelize.NewFile()
+		defer f.Close()
+		sw, err := f.NewStreamWriter("Sheet1")
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Failed to export data"})
+			return
+		}
+
+		headerRow := make([]interface{}, len(headers))
+		for i, h := range headers {
+			headerRow[i] = h
+		}
+		sw.SetRow("A1", headerRow)
+
+		for n := 2; rows.Next(); n++ {
+			var row T
+			if err := initializers.DB.ScanRows(rows, &row); err != nil {
+				c.JSON(500, gin.H{"message": "Failed to export data"})
+				return
+			}
+			values := make([]interface{}, len(columns))
+			for i, col := range columns {
+				values[i] = col.Value(row, lang)
+			}
+			cell, _ := excelize.CoordinatesToCellName(1, n)
+			if err := sw.SetRow(cell, values); err != nil {
+				c.JSON(500, gin.H{"message": "Failed to export data"})
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("export %s: %v", name, err)
+			c.JSON(500, gin.H{"message": "Failed to export data"})
+			return
+		}
+		if err := sw.Flush(); err != nil {
+			c.JSON(500, gin.H{"message": "Failed to export data"})
+			return
+		}
+
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(200)
+		if err := f.Write(c.Writer); err != nil {
+			abortExport(c, name, err)
+		}
+	}
+}
+
+// abortExport stops an export that failed after part of the file was sent. The status cannot change anymore,
+// so the connection is closed without ending the body and the client sees a failed download
+// instead of a truncated file.
+func abortExport(c *gin.Context, name string, err error) {
+	log.Printf("export %s: %v", name, err)
+	if !c.Writer.Written() {
+		c.JSON(500, gin.H{"message": "Failed to export data"})
+		return
+	}
+	c.Abort()
+	if hj, ok := c.Writer.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// csvValue formats a cell of a CSV export. Text starting like a formula is prefixed with a quote,
+// so a name such as "=HYPERLINK(...)" is not evaluated when the file is opened in a spreadsheet.
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if val != "" && strings.ContainsRune("=+-@\t\r", rune(val[0])) {
+			return "'" + val
+		}
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/media"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/dontkeep/simaling-backend/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// ServeSignedFile serves a stored file through the links returned by the API.
+// The link carries an expiring HMAC signature instead of a token so it works in <img> tags.
+func ServeSignedFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !initializers.FileURLSigner.Verify(key, c.Query("expires"), c.Query("signature")) {
+		c.JSON(403, gin.H{"message": "Invalid or expired link"})
+		return
+	}
+	serveStoredFile(c, key)
+}
+
+// GetFundsImage serves the cover image (first attachment) of a funds entry to its owner and to admins.
+// Use ?variant=thumbnail for the small preview.
+func GetFundsImage(c *gin.Context) {
+	id := c.Param("id")
+	var funds models.Funds
+
+	result := initializers.DB.Where("id = ?", id).First(&funds)
+	if result.Error != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+
+	if !canViewFunds(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	key, thumbnailKey := fundsCover(funds.ID)
+	if c.Query("variant") == "thumbnail" {
+		key = thumbnailKey
+	}
+	if key == "" {
+		c.JSON(404, gin.H{"message": "Funds has no image"})
+		return
+	}
+	serveStoredFile(c, imageStorageKey(key))
+}
+
+// canViewFunds checks if the current user submitted the funds entry or is an admin
+func canViewFunds(c *gin.Context, funds models.Funds) bool {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return false
+	}
+	uid, ok := userID.(uint)
+	if !ok {
+		return false
+	}
+	return funds.User_Id == uid || isAdmin(c)
+}
+
+// fundsImageViewer returns a check telling if the current user may see the receipt of a funds entry
+// submitted by ownerID, the role is looked up once so it can be used for every row of a list
+func fundsImageViewer(c *gin.Context) func(ownerID uint) bool {
+	admin := isAdmin(c)
+	uid, _ := currentUserID(c)
+	return func(ownerID uint) bool {
+		return admin || (uid != 0 && ownerID == uid)
+	}
+}
+
+func serveStoredFile(c *gin.Context, key string) {
+	file, err := initializers.Storage.Open(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		c.JSON(404, gin.H{"message": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(200)
+	io.Copy(c.Writer, file)
+}
+
+// saveUploadedImage validates, cleans and stores an uploaded image or PDF under prefix.
+// It returns the storage key of the file and of its thumbnail (empty for PDFs).
+// Errors from the media package are the client's fault, see uploadErrorMessage.
+func saveUploadedImage(c *gin.Context, file *multipart.FileHeader, prefix string) (string, string, error) {
+	if file.Size > initializers.AppConfig.UploadMaxSize {
+		return "", "", media.ErrTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	processed, err := media.Process(src, initializers.AppConfig.UploadMaxSize)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Generate a unique key, the extension comes from the detected content and not from the client
+	name := prefix + "/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	key := name + processed.Ext
+	ctx := c.Request.Context()
+	if err := initializers.Storage.Put(ctx, key, bytes.NewReader(processed.Data), int64(len(processed.Data)), processed.ContentType); err != nil {
+		return "", "", err
+	}
+
+	var thumbnailKey string
+	if processed.Thumbnail != nil {
+		thumbnailKey = name + "_thumb.jpg"
+		if err := initializers.Storage.Put(ctx, thumbnailKey, bytes.NewReader(processed.Thumbnail), int64(len(processed.Thumbnail)), "image/jpeg"); err != nil {
+			initializers.Storage.Delete(ctx, key)
+			return "", "", err
+		}
+	}
+	return key, thumbnailKey, nil
+}
+
+// deleteUploadedImage removes a file stored by saveUploadedImage, for example when the row referencing it
+// could not be saved. Errors are ignored, the upload cleanup job removes whatever is left behind.
+func deleteUploadedImage(c *gin.Context, key, thumbnailKey string) {
+	ctx := c.Request.Context()
+	if key != "" {
+		initializers.Storage.Delete(ctx, key)
+	}
+	if thumbnailKey != "" {
+		initializers.Storage.Delete(ctx, thumbnailKey)
+	}
+}
+
+// uploadErrorMessage returns the message to show for a rejected upload, or "" for server errors
+func uploadErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, media.ErrTooLarge):
+		return "Image is too large, the maximum is " + strconv.FormatInt(initializers.AppConfig.UploadMaxSize>>20, 10) + " MB"
+	case errors.Is(err, media.ErrUnsupportedType):
+		return "Unsupported image type, only JPEG, PNG, WebP and PDF are allowed"
+	case errors.Is(err, media.ErrInvalidImage):
+		return "Invalid or corrupted image"
+	}
+	return ""
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"mime"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// fundsAttachmentTypes lists the accepted attachment types
+var fundsAttachmentTypes = map[string]bool{
+	"receipt":        true,
+	"transfer_proof": true,
+	"invoice":        true,
+	"photo":          true,
+	"other":          true,
+}
+
+// The first attachment of a funds entry is its cover, listings return it as image and thumbnail
+const (
+	fundsCoverImageSelect     = "(SELECT funds_attachments.storage_key FROM funds_attachments WHERE funds_attachments.funds_id = funds.id AND funds_attachments.deleted_at IS NULL ORDER BY funds_attachments.position, funds_attachments.id LIMIT 1) as image"
+	fundsCoverThumbnailSelect = "(SELECT funds_attachments.thumbnail FROM funds_attachments WHERE funds_attachments.funds_id = funds.id AND funds_attachments.deleted_at IS NULL ORDER BY funds_attachments.position, funds_attachments.id LIMIT 1) as thumbnail"
+)
+
+type fundsAttachmentResponse struct {
+	ID          uint      `json:"id"`
+	Type        string    `json:"type"`
+	Caption     string    `json:"caption"`
+	Position    int       `json:"position"`
+	ContentType string    `json:"content_type"`
+	URL         string    `json:"url"`
+	Thumbnail   string    `json:"thumbnail"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func toFundsAttachmentResponse(a models.FundsAttachment) fundsAttachmentResponse {
+	return fundsAttachmentResponse{
+		ID:          a.ID,
+		Type:        a.Type,
+		Caption:     a.Caption,
+		Position:    a.Position,
+		ContentType: mime.TypeByExtension(path.Ext(a.Storage_Key)),
+		URL:         getFullImageURL(a.Storage_Key),
+		Thumbnail:   getFullImageURL(a.Thumbnail),
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+// fundsAttachments returns the attachments of a funds entry in display order
+func fundsAttachments(fundsID uint) ([]fundsAttachmentResponse, error) {
+	var attachments []models.FundsAttachment
+	err := initializers.DB.Where("funds_id = ?", fundsID).Order("position ASC, id ASC").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	response := make([]fundsAttachmentResponse, len(attachments))
+	for i, a := range attachments {
+		response[i] = toFundsAttachmentResponse(a)
+	}
+	return response, nil
+}
+
+// fundsCover returns the storage keys of the first attachment of a funds entry
+func fundsCover(fundsID uint) (string, string) {
+	var cover models.FundsAttachment
+	if err := initializers.DB.Where("funds_id = ?", fundsID).Order("position ASC, id ASC").First(&cover).Error; err != nil {
+		return "", ""
+	}
+	return cover.Storage_Key, cover.Thumbnail
+}
+
+// defaultAttachmentType is used when no type is given: income is paid by transfer, expenses come with a receipt
+func defaultAttachmentType(funds models.Funds) string {
+	if funds.Is_Income {
+		return "transfer_proof"
+	}
+	return "receipt"
+}
+
+// canEditFundsAttachments lets admins change attachments at any time,
+// the owner only until the entry has been accepted
+func canEditFundsAttachments(c *gin.Context, funds models.Funds) bool {
+	if isAdmin(c) {
+		return true
+	}
+	return canViewFunds(c, funds) && funds.Status != "Accepted"
+}
+
+// GetFundsAttachments lists the attachments of a funds entry
+func GetFundsAttachments(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canViewFunds(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	attachments, err := fundsAttachments(funds.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get attachments"})
+		return
+	}
+	c.JSON(200, gin.H{"data": attachments})
+}
+
+// AddFundsAttachment uploads a file (form field "file") with optional type, caption and position
+func AddFundsAttachment(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canEditFundsAttachments(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	attachmentType := c.DefaultPostForm("type", defaultAttachmentType(funds))
+	if !fundsAttachmentTypes[attachmentType] {
+		c.JSON(400, gin.H{"message": "Invalid attachment type"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"message": "File is required"})
+		return
+	}
+
+	// New attachments go last unless a position is given
+	position := -1
+	if positionStr := c.PostForm("position"); positionStr != "" {
+		positionInt, err := strconv.Atoi(positionStr)
+		if err != nil || positionInt < 0 {
+			c.JSON(400, gin.H{"message": "Invalid position"})
+			return
+		}
+		position = positionInt
+	}
+	if position < 0 {
+		var last struct{ Max *int }
+		initializers.DB.Model(&models.FundsAttachment{}).Select("MAX(position) as max").Where("funds_id = ?", funds.ID).Scan(&last)
+		position = 0
+		if last.Max != nil {
+			position = *last.Max + 1
+		}
+	}
+
+	key, thumbnailKey, err := saveUploadedImage(c, file, "funds")
+	if err != nil {
+		if message := uploadErrorMessage(err); message != "" {
+			c.JSON(400, gin.H{"message": message})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to save attachment"})
+		return
+	}
+
+	attachment := models.FundsAttachment{
+		Funds_Id:    funds.ID,
+		Type:        attachmentType,
+		Caption:     c.PostForm("caption"),
+		Position:    position,
+		Storage_Key: key,
+		Thumbnail:   thumbnailKey,
+	}
+	if err := initializers.DB.Create(&attachment).Error; err != nil {
+		deleteUploadedImage(c, key, thumbnailKey)
+		c.JSON(500, gin.H{"message": "Failed to save attachment"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Attachment added",
+		"data":    toFundsAttachmentResponse(attachment),
+	})
+}
+
+// UpdateFundsAttachment changes the type, caption or position of an attachment
+func UpdateFundsAttachment(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canEditFundsAttachments(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	var attachment models.FundsAttachment
+	if err := initializers.DB.Where("id = ? AND funds_id = ?", c.Param("attachmentId"), funds.ID).First(&attachment).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Attachment not found"})
+		return
+	}
+
+	var body struct {
+		Type     *string `json:"type"`
+		Caption  *string `json:"caption"`
+		Position *int    `json:"position"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	if body.Type != nil {
+		if !fundsAttachmentTypes[*body.Type] {
+			c.JSON(400, gin.H{"message": "Invalid attachment type"})
+			return
+		}
+		attachment.Type = *body.Type
+	}
+	if body.Caption != nil {
+		attachment.Caption = *body.Caption
+	}
+	if body.Position != nil {
+		if *body.Position < 0 {
+			c.JSON(400, gin.H{"message": "Invalid position"})
+			return
+		}
+		attachment.Position = *body.Position
+	}
+
+	if err := initializers.DB.Save(&attachment).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update attachment"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Attachment updated",
+		"data":    toFundsAttachmentResponse(attachment),
+	})
+}
+
+// ReorderFundsAttachments sets the order of the attachments from a list of attachment ids
+func ReorderFundsAttachments(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canEditFundsAttachments(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	var body struct {
+		IDs []uint `json:"ids"`
+	}
+	if err := c.BindJSON(&body); err != nil || len(body.IDs) == 0 {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	var count int64
+	initializers.DB.Model(&models.FundsAttachment{}).Where("funds_id = ? AND id IN ?", funds.ID, body.IDs).Count(&count)
+	if count != int64(len(body.IDs)) {
+		c.JSON(400, gin.H{"message": "Unknown or duplicated attachment id"})
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		for position, id := range body.IDs {
+			if err := tx.Model(&models.FundsAttachment{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to reorder attachments"})
+		return
+	}
+
+	attachments, err := fundsAttachments(funds.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get attachments"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "Attachments reordered",
+		"data":    attachments,
+	})
+}
+
+// DeleteFundsAttachment removes an attachment and its files
+func DeleteFundsAttachment(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canEditFundsAttachments(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	var attachment models.FundsAttachment
+	if err := initializers.DB.Where("id = ? AND funds_id = ?", c.Param("attachmentId"), funds.ID).First(&attachment).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Attachment not found"})
+		return
+	}
+
+	if err := initializers.DB.Delete(&attachment).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete attachment"})
+		return
+	}
+
+	// The row is gone, a file that fails to delete here is left for the cleanup job
+	deleteUploadedImage(c, attachment.Storage_Key, attachment.Thumbnail)
+
+	c.JSON(200, gin.H{"message": "Attachment deleted"})
+}
+
+// GetFundsAttachmentFile serves an attachment to the owner of the funds entry and to admins.
+// Use ?variant=thumbnail for the small preview.
+func GetFundsAttachmentFile(c *gin.Context) {
+	var funds models.Funds
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&funds).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Funds not found"})
+		return
+	}
+	if !canViewFunds(c, funds) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	var attachment models.FundsAttachment
+	if err := initializers.DB.Where("id = ? AND funds_id = ?", c.Param("attachmentId"), funds.ID).First(&attachment).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Attachment not found"})
+		return
+	}
+
+	key := attachment.Storage_Key
+	if c.Query("variant") == "thumbnail" {
+		key = attachment.Thumbnail
+	}
+	if key == "" {
+		c.JSON(404, gin.H{"message": "Attachment has no thumbnail"})
+		return
+	}
+	serveStoredFile(c, imageStorageKey(key))
+}
@@ -1,57 +1,101 @@
 package controllers
 
 import (
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 	"strings"
 
+	"github.com/dontkeep/simaling-backend/events"
 	"github.com/dontkeep/simaling-backend/initializers"
 	"github.com/dontkeep/simaling-backend/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-// getFullImageURL constructs the full URL for an image based on the base URL and image path
-func getFullImageURL(imagePath string) string {
-    if imagePath == "" {
-        return ""
-    }
-    return initializers.AppConfig.BaseURL + "/" + strings.Replace(imagePath, "\\", "/", -1)
+// getFullImageURL resolves the URL of a stored image through the active storage backend
+func getFullImageURL(imageKey string) string {
+	if imageKey == "" {
+		return ""
+	}
+	return initializers.Storage.URL(imageStorageKey(imageKey))
 }
 
-func GetIncomeFunds(c *gin.Context) {
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
+// imageStorageKey converts image values saved before the storage backend existed
+// ("uploads/123.jpg" or "uploads\\123.jpg") into storage keys
+func imageStorageKey(image string) string {
+	image = strings.Replace(image, "\\", "/", -1)
+	return strings.TrimPrefix(image, "uploads/")
+}
 
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{"message": "Invalid page number"})
-		return
+// fundsExportSelect selects the columns scanned into fundsExportRow by the CSV/XLSX exports
+const fundsExportSelect = "funds.id, funds.user_id, funds.created_at, users.name as user_name, funds.block, funds.category, funds.description, funds.is_income, funds.status, funds.amount, " + fundsCoverImageSelect
+
+type fundsExportRow struct {
+	ID          uint
+	User_Id     uint
+	CreatedAt   time.Time
+	UserName    string
+	Block       string
+	Category    string
+	Description string
+	Is_Income   bool
+	Status      string
+	Amount      float64
+	Image       string
+}
+
+// fundsExportColumns lists the columns of the funds exports, the proof link is only filled in
+// for the rows the current user may see the receipt of
+func fundsExportColumns(c *gin.Context) []exportColumn[fundsExportRow] {
+	canView := fundsImageViewer(c)
+	return []exportColumn[fundsExportRow]{
+		{"ID", "ID", func(r fundsExportRow, _ string) interface{} { return r.ID }},
+		{"Tanggal", "Date", func(r fundsExportRow, _ string) interface{} { return exportTime(r.CreatedAt) }},
+		{"Nama", "Name", func(r fundsExportRow, _ string) interface{} { return r.UserName }},
+		{"Blok", "Block", func(r fundsExportRow, _ string) interface{} { return r.Block }},
+		{"Kategori", "Category", func(r fundsExportRow, _ string) interface{} { return r.Category }},
+		{"Keterangan", "Description", func(r fundsExportRow, _ string) interface{} { return r.Description }},
+		{"Jenis", "Type", func(r fundsExportRow, lang string) interface{} {
+			if r.Is_Income {
+				return localize(lang, "Pemasukan", "Income")
+			}
+			return localize(lang, "Pengeluaran", "Expense")
+		}},
+		{"Status", "Status", func(r fundsExportRow, lang string) interface{} { return fundsStatusLabel(r.Status, lang) }},
+		{"Jumlah", "Amount", func(r fundsExportRow, _ string) interface{} { return r.Amount }},
+		{"Bukti", "Proof", func(r fundsExportRow, _ string) interface{} {
+			if !canView(r.User_Id) {
+				return ""
+			}
+			return getFullImageURL(r.Image)
+		}},
 	}
+}
 
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{"message": "Invalid limit number"})
+// fundsStatusLabel translates the known funds statuses for exports
+func fundsStatusLabel(status, lang string) string {
+	switch status {
+	case "Accepted":
+		return localize(lang, "Diterima", "Accepted")
+	case "Rejected":
+		return localize(lang, "Ditolak", "Rejected")
+	case "Pending":
+		return localize(lang, "Menunggu", "Pending")
+	}
+	return status
+}
+
+func GetIncomeFunds(c *gin.Context) {
+	filter, err := parseFundsListFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	offset := (pageInt - 1) * limitInt
-
-	// Month/year filter
-	monthStr := c.Query("month")
-	yearStr := c.Query("year")
-	var startTime, endTime time.Time
-	useDateFilter := false
-	if monthStr != "" && yearStr != "" {
-		month, err1 := strconv.Atoi(monthStr)
-		year, err2 := strconv.Atoi(yearStr)
-		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && year > 0 {
-			startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-			endTime = startTime.AddDate(0, 1, 0)
-			useDateFilter = true
-		}
+	pagination, err := parsePagination(c, filter.sort)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
 	}
 	
 	type FundsResponse struct {
@@ -62,159 +106,127 @@ func GetIncomeFunds(c *gin.Context) {
 		Block       string    `json:"block"`
 		UserName    string    `json:"user_name"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
+		Category    string    `json:"category"`
 		CreatedAt   time.Time `json:"created_at"`
+		UserId      uint      `json:"-"`
 	}
 
 	var funds []FundsResponse
 	db := initializers.DB.Model(&models.Funds{}).
-		Select("funds.id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, funds.image, funds.description, funds.created_at"). // Tambahkan funds.description
+		Select("funds.id, funds.user_id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, " + fundsCoverImageSelect + ", " + fundsCoverThumbnailSelect + ", funds.description, funds.category, funds.created_at"). // Tambahkan funds.description
 		Joins("left join users on users.id = funds.user_id").
 		Where("funds.is_income = ?", true)
-	if useDateFilter {
-		db = db.Where("funds.created_at >= ? AND funds.created_at < ?", startTime, endTime)
+	db = filter.apply(db)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "funds-income", db.Select(fundsExportSelect).Order(filter.sort.clause(false)), fundsExportColumns(c))
+		return
 	}
-	db = db.Limit(limitInt).Offset(offset)
+	db = pagination.apply(db)
 	result := db.Scan(&funds)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get income funds"})
 		return
 	}
-
-	var total int64
-	totalDB := initializers.DB.Model(&models.Funds{}).Where("is_income = ?", true)
-	if useDateFilter {
-		totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+	// Receipts are only signed for admins and the user who submitted the funds entry
+	canView := fundsImageViewer(c)
+	for i := range funds {
+		if !canView(funds[i].UserId) {
+			funds[i].Image, funds[i].Thumbnail = "", ""
+			continue
+		}
+		funds[i].Image = getFullImageURL(funds[i].Image)
+		funds[i].Thumbnail = getFullImageURL(funds[i].Thumbnail)
 	}
-	totalDB.Count(&total)
 
-	c.JSON(200, gin.H{
-		"data":       funds,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt),
+	response := paginate(pagination, funds, func() int64 {
+		var total int64
+		totalDB := initializers.DB.Model(&models.Funds{}).Where("is_income = ?", true)
+		filter.apply(totalDB).Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 func GetExpenseFunds(c *gin.Context) {
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
-
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{"message": "Invalid page number"})
+	filter, err := parseFundsListFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{"message": "Invalid limit number"})
+	pagination, err := parsePagination(c, filter.sort)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	offset := (pageInt - 1) * limitInt
-
-	// Month/year filter
-	monthStr := c.Query("month")
-	yearStr := c.Query("year")
-	var startTime, endTime time.Time
-	useDateFilter := false
-	if monthStr != "" && yearStr != "" {
-		month, err1 := strconv.Atoi(monthStr)
-		year, err2 := strconv.Atoi(yearStr)
-		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && year > 0 {
-			startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-			endTime = startTime.AddDate(0, 1, 0)
-			useDateFilter = true
-		}
-	}
-
 	type FundsResponse struct {
-		ID        uint      `json:"id"`
-		Is_Income bool      `json:"is_income"`
-		Status    string    `json:"status"`
-		Amount    float64   `json:"amount"`
-		Block     string    `json:"block"`
-		UserName  string    `json:"user_name"`
-		Image     string    `json:"image"`
-		CreatedAt time.Time `json:"created_at"`
+		ID          uint      `json:"id"`
+		Is_Income   bool      `json:"is_income"`
+		Status      string    `json:"status"`
+		Amount      float64   `json:"amount"`
+		Block       string    `json:"block"`
+		UserName    string    `json:"user_name"`
+		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
+		Description string    `json:"description"`
+		Category    string    `json:"category"`
+		CreatedAt   time.Time `json:"created_at"`
+		UserId      uint      `json:"-"`
 	}
 
 	var funds []FundsResponse
 	db := initializers.DB.Model(&models.Funds{}).
-		Select("funds.id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, funds.image, funds.description, funds.created_at").
+		Select("funds.id, funds.user_id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, "+fundsCoverImageSelect+", "+fundsCoverThumbnailSelect+", funds.description, funds.category, funds.created_at").
 		Joins("left join users on users.id = funds.user_id").
-		Where("funds.is_income = ?", true)
-	if useDateFilter {
-		db = db.Where("funds.created_at >= ? AND funds.created_at < ?", startTime, endTime)
+		Where("funds.is_income = ?", false)
+	db = filter.apply(db)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "funds-expense", db.Select(fundsExportSelect).Order(filter.sort.clause(false)), fundsExportColumns(c))
+		return
 	}
-	db = db.Limit(limitInt).Offset(offset)
+	db = pagination.apply(db)
 	result := db.Scan(&funds)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get expense funds"})
 		return
 	}
-
-	var total int64
-	totalDB := initializers.DB.Model(&models.Funds{}).Where("is_income = ?", false)
-	if useDateFilter {
-		totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+	// Receipts are only signed for admins and the user who submitted the funds entry
+	canView := fundsImageViewer(c)
+	for i := range funds {
+		if !canView(funds[i].UserId) {
+			funds[i].Image, funds[i].Thumbnail = "", ""
+			continue
+		}
+		funds[i].Image = getFullImageURL(funds[i].Image)
+		funds[i].Thumbnail = getFullImageURL(funds[i].Thumbnail)
 	}
-	totalDB.Count(&total)
 
-	c.JSON(200, gin.H{
-		"data":       funds,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt),
+	response := paginate(pagination, funds, func() int64 {
+		var total int64
+		totalDB := initializers.DB.Model(&models.Funds{}).Where("is_income = ?", false)
+		filter.apply(totalDB).Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 // GetFunds gets all funds
 func GetFunds(c *gin.Context) {
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")    // Default to page 1 if not provided
-	limit := c.DefaultQuery("limit", "10") // Default to 10 records per page if not provided
-
-	// Convert query parameters to integers
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid page number",
-		})
+	filter, err := parseFundsListFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid limit number",
-		})
+	pagination, err := parsePagination(c, filter.sort)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	// Calculate the offset
-	offset := (pageInt - 1) * limitInt
-
-	// Month/year filter
-	monthStr := c.Query("month") // 1-12
-	yearStr := c.Query("year")   // e.g. 2025
-
-	var startTime, endTime time.Time
-	useDateFilter := false
-	if monthStr != "" && yearStr != "" {
-		month, err1 := strconv.Atoi(monthStr)
-		year, err2 := strconv.Atoi(yearStr)
-		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && year > 0 {
-			startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-			endTime = startTime.AddDate(0, 1, 0)
-			useDateFilter = true
-		}
-	}
-
 	// Retrieve paginated funds from the database with selected fields and join user name
 	type FundsResponse struct {
 		ID          uint      `json:"id"`
@@ -224,18 +236,23 @@ func GetFunds(c *gin.Context) {
 		Block       string    `json:"block"`
 		UserName    string    `json:"user_name"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
+		Category    string    `json:"category"`
 		CreatedAt   time.Time `json:"created_at"`
+		UserId      uint      `json:"-"`
 	}
 
 	var funds []FundsResponse
 	db := initializers.DB.Model(&models.Funds{}).
-		Select("funds.id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, funds.image, funds.description, funds.created_at").
+		Select("funds.id, funds.user_id, funds.is_income, funds.status, funds.amount, funds.block, users.name as user_name, "+fundsCoverImageSelect+", "+fundsCoverThumbnailSelect+", funds.description, funds.category, funds.created_at").
 		Joins("left join users on users.id = funds.user_id")
-	if useDateFilter {
-		db = db.Where("funds.created_at >= ? AND funds.created_at < ?", startTime, endTime)
+	db = filter.apply(db)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "funds", db.Select(fundsExportSelect).Order(filter.sort.clause(false)), fundsExportColumns(c))
+		return
 	}
-	db = db.Limit(limitInt).Offset(offset)
+	db = pagination.apply(db)
 	result := db.Scan(&funds)
 	if result.Error != nil {
 		c.JSON(400, gin.H{
@@ -243,23 +260,25 @@ func GetFunds(c *gin.Context) {
 		})
 		return
 	}
-
-	// Count the total number of funds
-	var total int64
-	totalDB := initializers.DB.Model(&models.Funds{})
-	if useDateFilter {
-		totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+	// Receipts are only signed for admins and the user who submitted the funds entry
+	canView := fundsImageViewer(c)
+	for i := range funds {
+		if !canView(funds[i].UserId) {
+			funds[i].Image, funds[i].Thumbnail = "", ""
+			continue
+		}
+		funds[i].Image = getFullImageURL(funds[i].Image)
+		funds[i].Thumbnail = getFullImageURL(funds[i].Thumbnail)
 	}
-	totalDB.Count(&total)
 
-	// Return the paginated response
-	c.JSON(200, gin.H{
-		"data":       funds,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt), // Calculate total pages
+	// Count the total number of funds and return the paginated response
+	response := paginate(pagination, funds, func() int64 {
+		var total int64
+		totalDB := initializers.DB.Model(&models.Funds{})
+		filter.apply(totalDB).Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 // CreateFunds creates a new funds
@@ -267,25 +286,23 @@ func CreateFunds(c *gin.Context) {
 	// Parse form data
 	amount := c.PostForm("amount")
 	description := c.PostForm("description")
+	category := c.PostForm("category")
 	isIncome := c.PostForm("is_income")
 	status := c.PostForm("status")
 	block := c.PostForm("block")
 
 	// Parse the uploaded file
 	file, err := c.FormFile("image")
-	var filePath string
+	var imageKey, thumbnailKey string
 	if err == nil {
-		// Save the file to the uploads directory
-		uploadsDir := "uploads"
-		if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-			os.Mkdir(uploadsDir, os.ModePerm)
-		}
-
-		// Generate a unique file name
-		fileName := strconv.FormatInt(time.Now().UnixNano(), 10) + filepath.Ext(file.Filename)
-		filePath = filepath.Join(uploadsDir, fileName)
-
-		if err := c.SaveUploadedFile(file, filePath); err != nil {
+		imageKey, thumbnailKey, err = saveUploadedImage(c, file, "funds")
+		if err != nil {
+			if message := uploadErrorMessage(err); message != "" {
+				c.JSON(400, gin.H{
+					"message": message,
+				})
+				return
+			}
 			c.JSON(500, gin.H{
 				"message": "Failed to save image",
 			})
@@ -317,15 +334,30 @@ func CreateFunds(c *gin.Context) {
 	funds := models.Funds{
 		User_Id:     uid,
 		Amount:      amountFloat,
-		Image:       filePath,
 		Description: description,
+		Category:    category,
 		Is_Income:   isIncomeBool,
 		Status:      status,
 		Block:       block,
 	}
 
-	// Save the record to the database
-	if err := initializers.DB.Create(&funds).Error; err != nil {
+	// Save the record to the database, the uploaded image becomes the first attachment
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&funds).Error; err != nil {
+			return err
+		}
+		if imageKey == "" {
+			return nil
+		}
+		return tx.Create(&models.FundsAttachment{
+			Funds_Id:    funds.ID,
+			Type:        defaultAttachmentType(funds),
+			Storage_Key: imageKey,
+			Thumbnail:   thumbnailKey,
+		}).Error
+	})
+	if err != nil {
+		deleteUploadedImage(c, imageKey, thumbnailKey)
 		c.JSON(500, gin.H{
 			"message": "Failed to create funds record",
 		})
@@ -336,6 +368,7 @@ func CreateFunds(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
@@ -353,7 +386,8 @@ func CreateFunds(c *gin.Context) {
 	response := FundsResponse{
 		ID:          funds.ID,
 		Amount:      funds.Amount,
-		Image:       funds.Image,
+		Image:       getFullImageURL(imageKey),
+		Thumbnail:   getFullImageURL(thumbnailKey),
 		Description: funds.Description,
 		Is_Income:   funds.Is_Income,
 		Status:      funds.Status,
@@ -362,6 +396,8 @@ func CreateFunds(c *gin.Context) {
 		Block:       funds.Block,
 	}
 
+	publishEvent("funds.submitted", response, events.Audience{Roles: []uint{1}, Users: []uint{funds.User_Id}})
+
 	c.JSON(200, gin.H{
 		"message": "Funds record created successfully",
 		"data":    response,
@@ -393,6 +429,7 @@ func DeleteFunds(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
@@ -400,10 +437,12 @@ func DeleteFunds(c *gin.Context) {
 		Block       string    `json:"block"`
 	}
 
+	imageKey, thumbnailKey := fundsCover(funds.ID)
 	response := FundsResponse{
 		ID:          funds.ID,
 		Amount:      funds.Amount,
-		Image:       funds.Image,
+		Image:       getFullImageURL(imageKey),
+		Thumbnail:   getFullImageURL(thumbnailKey),
 		Description: funds.Description,
 		Is_Income:   funds.Is_Income,
 		Status:      funds.Status,
@@ -423,6 +462,7 @@ func UpdateFunds(c *gin.Context) {
 		Amount      int
 		Is_Income   bool
 		Description string
+		Category    string
 	}
 
 	if err := c.BindJSON(&body); err != nil {
@@ -446,6 +486,9 @@ func UpdateFunds(c *gin.Context) {
 	funds.Amount = float64(body.Amount)
 	funds.Is_Income = body.Is_Income
 	funds.Description = body.Description
+	if body.Category != "" {
+		funds.Category = body.Category
+	}
 
 	result = initializers.DB.Save(&funds)
 	if result.Error != nil {
@@ -463,44 +506,18 @@ func UpdateFunds(c *gin.Context) {
 
 // GetFundsByUser gets all funds by user
 func GetFundsByUser(c *gin.Context) {
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
-
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid page number",
-		})
+	filter, err := parseFundsListFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid limit number",
-		})
+	pagination, err := parsePagination(c, filter.sort)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	offset := (pageInt - 1) * limitInt
-
-	// Month/year filter
-	monthStr := c.Query("month") // 1-12
-	yearStr := c.Query("year")   // e.g. 2025
-
-	var startTime, endTime time.Time
-	useDateFilter := false
-	if monthStr != "" && yearStr != "" {
-		month, err1 := strconv.Atoi(monthStr)
-		year, err2 := strconv.Atoi(yearStr)
-		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && year > 0 {
-			startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-			endTime = startTime.AddDate(0, 1, 0)
-			useDateFilter = true
-		}
-	}
-
 	// Retrieve user ID from context
 	userId, exists := c.Get("user_id")
 	if !exists {
@@ -521,7 +538,9 @@ func GetFundsByUser(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
+		Category    string    `json:"category"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
 		CreatedAt   time.Time `json:"created_at"`
@@ -531,13 +550,15 @@ func GetFundsByUser(c *gin.Context) {
 
 	var funds []FundsResponse
 	db := initializers.DB.Model(&models.Funds{}).
-		Select("funds.id, funds.amount, funds.image, funds.description, funds.is_income, funds.status, funds.created_at, users.name as user_name, funds.block").
+		Select("funds.id, funds.amount, "+fundsCoverImageSelect+", "+fundsCoverThumbnailSelect+", funds.description, funds.category, funds.is_income, funds.status, funds.created_at, users.name as user_name, funds.block").
 		Joins("left join users on users.id = funds.user_id").
 		Where("funds.user_id = ?", uid)
-	if useDateFilter {
-		db = db.Where("funds.created_at >= ? AND funds.created_at < ?", startTime, endTime)
+	db = filter.apply(db)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "funds-by-user", db.Select(fundsExportSelect).Order(filter.sort.clause(false)), fundsExportColumns(c))
+		return
 	}
-	db = db.Limit(limitInt).Offset(offset)
+	db = pagination.apply(db)
 	result := db.Scan(&funds)
 	if result.Error != nil {
 		c.JSON(400, gin.H{
@@ -545,23 +566,18 @@ func GetFundsByUser(c *gin.Context) {
 		})
 		return
 	}
-
-	// Count the total number of funds for the user
-	var total int64
-	totalDB := initializers.DB.Model(&models.Funds{}).Where("user_id = ?", uid)
-	if useDateFilter {
-		totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+	for i := range funds {
+		funds[i].Image = getFullImageURL(funds[i].Image)
+		funds[i].Thumbnail = getFullImageURL(funds[i].Thumbnail)
 	}
-	totalDB.Count(&total)
 
-	// Return the paginated response
-	c.JSON(200, gin.H{
-		"data":       funds,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt),
+	response := paginate(pagination, funds, func() int64 {
+		var total int64
+		totalDB := initializers.DB.Model(&models.Funds{}).Where("user_id = ?", uid)
+		filter.apply(totalDB).Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 // GetFundsById gets a funds by id'
@@ -584,27 +600,51 @@ func GetFundsById(c *gin.Context) {
 	}
 
 	type FundsResponse struct {
-		ID          uint      `json:"id"`
-		Amount      float64   `json:"amount"`
-		Image       string    `json:"image"`
-		Description string    `json:"description"`
-		Is_Income   bool      `json:"is_income"`
-		Status      string    `json:"status"`
-		CreatedAt   time.Time `json:"created_at"`
-		UserName    string    `json:"user_name"`
-		Block       string    `json:"block"`
+		ID          uint                      `json:"id"`
+		Amount      float64                   `json:"amount"`
+		Image       string                    `json:"image"`
+		Thumbnail   string                    `json:"thumbnail"`
+		Description string                    `json:"description"`
+		Is_Income   bool                      `json:"is_income"`
+		Status      string                    `json:"status"`
+		CreatedAt   time.Time                 `json:"created_at"`
+		UserName    string                    `json:"user_name"`
+		Block       string                    `json:"block"`
+		Attachments []fundsAttachmentResponse `json:"attachments"`
+	}
+
+	// Receipts are only shown to admins and the user who submitted the funds entry
+	attachments := []fundsAttachmentResponse{}
+	if canViewFunds(c, funds) {
+		var err error
+		attachments, err = fundsAttachments(funds.ID)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"message": "Failed to get attachments",
+			})
+			return
+		}
+	}
+
+	// The first attachment is the cover image
+	var imageURL, thumbnailURL string
+	if len(attachments) > 0 {
+		imageURL = attachments[0].URL
+		thumbnailURL = attachments[0].Thumbnail
 	}
 
 	response := FundsResponse{
 		ID:          funds.ID,
 		Amount:      funds.Amount,
-        Image:       getFullImageURL(funds.Image),
+		Image:       imageURL,
+		Thumbnail:   thumbnailURL,
 		Description: funds.Description,
 		Is_Income:   funds.Is_Income,
 		Status:      funds.Status,
 		CreatedAt:   funds.CreatedAt,
 		UserName:    user.Name,
 		Block:       funds.Block,
+		Attachments: attachments,
 	}
 
 	c.JSON(200, gin.H{
@@ -647,6 +687,7 @@ func AcceptFunds(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
@@ -655,10 +696,12 @@ func AcceptFunds(c *gin.Context) {
 		Block       string    `json:"block"`
 	}
 
+	imageKey, thumbnailKey := fundsCover(funds.ID)
 	response := FundsResponse{
 		ID:          funds.ID,
 		Amount:      funds.Amount,
-		Image:       funds.Image,
+		Image:       getFullImageURL(imageKey),
+		Thumbnail:   getFullImageURL(thumbnailKey),
 		Description: funds.Description,
 		Is_Income:   funds.Is_Income,
 		Status:      funds.Status,
@@ -667,6 +710,8 @@ func AcceptFunds(c *gin.Context) {
 		Block:       funds.Block,
 	}
 
+	publishEvent("funds.accepted", response, events.Audience{Roles: []uint{1}, Users: []uint{funds.User_Id}})
+
 	c.JSON(200, gin.H{
 		"message": "Funds accepted",
 		"data":    response,
@@ -708,6 +753,7 @@ func RejectFunds(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
@@ -716,10 +762,12 @@ func RejectFunds(c *gin.Context) {
 		Block       string    `json:"block"`
 	}
 
+	imageKey, thumbnailKey := fundsCover(funds.ID)
 	response := FundsResponse{
 		ID:          funds.ID,
 		Amount:      funds.Amount,
-		Image:       funds.Image,
+		Image:       getFullImageURL(imageKey),
+		Thumbnail:   getFullImageURL(thumbnailKey),
 		Description: funds.Description,
 		Is_Income:   funds.Is_Income,
 		Status:      funds.Status,
@@ -728,6 +776,8 @@ func RejectFunds(c *gin.Context) {
 		Block:       funds.Block,
 	}
 
+	publishEvent("funds.rejected", response, events.Audience{Roles: []uint{1}, Users: []uint{funds.User_Id}})
+
 	c.JSON(200, gin.H{
 		"message": "Funds rejected",
 		"data":    response,
@@ -775,6 +825,7 @@ func GetFundsByMonthAndYear(c *gin.Context) {
 		ID          uint      `json:"id"`
 		Amount      float64   `json:"amount"`
 		Image       string    `json:"image"`
+		Thumbnail   string    `json:"thumbnail"`
 		Description string    `json:"description"`
 		Is_Income   bool      `json:"is_income"`
 		Status      string    `json:"status"`
@@ -784,15 +835,23 @@ func GetFundsByMonthAndYear(c *gin.Context) {
 	}
 
 	var funds []FundsResponse
-	result := initializers.DB.Model(&models.Funds{}).
-		Select("funds.id, funds.amount, funds.image, funds.description, funds.is_income, funds.status, funds.created_at, users.name as user_name, funds.block").
+	db := initializers.DB.Model(&models.Funds{}).
+		Select("funds.id, funds.amount, "+fundsCoverImageSelect+", "+fundsCoverThumbnailSelect+", funds.description, funds.is_income, funds.status, funds.created_at, users.name as user_name, funds.block").
 		Joins("left join users on users.id = funds.user_id").
-		Where("funds.user_id = ? AND funds.created_at >= ? AND funds.created_at < ?", uid, startTime, endTime).
-		Scan(&funds)
+		Where("funds.user_id = ? AND funds.created_at >= ? AND funds.created_at < ?", uid, startTime, endTime)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "funds-by-month-year", db.Select(fundsExportSelect).Order("funds.created_at DESC"), fundsExportColumns(c))
+		return
+	}
+	result := db.Scan(&funds)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get funds for the specified month and year"})
 		return
 	}
+	for i := range funds {
+		funds[i].Image = getFullImageURL(funds[i].Image)
+		funds[i].Thumbnail = getFullImageURL(funds[i].Thumbnail)
+	}
 
 	c.JSON(200, gin.H{"data": funds})
 }
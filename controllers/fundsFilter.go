@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// fundsSortFields whitelists the fields accepted by the sort query parameter.
+// users is left joined, so user_name is sorted as "" for funds without a user: a NULL would never
+// compare equal or greater in the keyset condition and the cursor would skip or repeat rows.
+var fundsSortFields = map[string]sortField{
+	"id":         fundsIDSortField,
+	"created_at": {Key: "created_at", Column: "funds.created_at", IsTime: true},
+	"amount":     {Key: "amount", Column: "funds.amount"},
+	"status":     {Key: "status", Column: "funds.status"},
+	"block":      {Key: "block", Column: "funds.block"},
+	"category":   {Key: "category", Column: "funds.category"},
+	"user_name":  {Key: "user_name", Column: "COALESCE(users.name, '')"},
+}
+
+var fundsIDSortField = sortField{Key: "id", Column: "funds.id"}
+
+// fundsListFilter holds the filters and sort order shared by the funds listings
+// (GetFunds, GetIncomeFunds, GetExpenseFunds and GetFundsByUser).
+type fundsListFilter struct {
+	useDateFilter      bool
+	startTime, endTime time.Time
+	rangeStart         time.Time
+	rangeEnd           time.Time
+	statuses           []string
+	block              string
+	category           string
+	userID             uint
+	minAmount          *float64
+	maxAmount          *float64
+	search             string
+	sort               sortOrder
+}
+
+// parseFundsListFilter reads the filter query parameters:
+//
+//	month, year           funds created in that month (both required)
+//	start_date, end_date  funds created between the dates, inclusive (YYYY-MM-DD)
+//	status                one or more comma separated statuses
+//	block, category       exact match
+//	user_id               funds submitted by that user
+//	min_amount, max_amount
+//	q                     free-text search in the description
+//	sort                  comma separated fields, prefix with "-" for descending (default "-created_at")
+func parseFundsListFilter(c *gin.Context) (fundsListFilter, error) {
+	var f fundsListFilter
+
+	// Month/year filter, invalid values are ignored as they always were
+	monthStr := c.Query("month") // 1-12
+	yearStr := c.Query("year")   // e.g. 2025
+	if monthStr != "" && yearStr != "" {
+		month, err1 := strconv.Atoi(monthStr)
+		year, err2 := strconv.Atoi(yearStr)
+		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && year > 0 {
+			f.startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+			f.endTime = f.startTime.AddDate(0, 1, 0)
+			f.useDateFilter = true
+		}
+	}
+
+	// Arbitrary date range
+	if startStr := c.Query("start_date"); startStr != "" {
+		start, err := time.ParseInLocation("2006-01-02", startStr, time.Local)
+		if err != nil {
+			return f, fmt.Errorf("Invalid start_date. Use YYYY-MM-DD.")
+		}
+		f.rangeStart = start
+	}
+	if endStr := c.Query("end_date"); endStr != "" {
+		end, err := time.ParseInLocation("2006-01-02", endStr, time.Local)
+		if err != nil {
+			return f, fmt.Errorf("Invalid end_date. Use YYYY-MM-DD.")
+		}
+		f.rangeEnd = end.AddDate(0, 0, 1)
+	}
+	if !f.rangeStart.IsZero() && !f.rangeEnd.IsZero() && !f.rangeStart.Before(f.rangeEnd) {
+		return f, fmt.Errorf("start_date must not be after end_date")
+	}
+
+	if statusStr := c.Query("status"); statusStr != "" {
+		for _, status := range strings.Split(statusStr, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				f.statuses = append(f.statuses, status)
+			}
+		}
+	}
+
+	f.block = strings.TrimSpace(c.Query("block"))
+	f.category = strings.TrimSpace(c.Query("category"))
+	f.search = strings.TrimSpace(c.Query("q"))
+
+	if userStr := c.Query("user_id"); userStr != "" {
+		uid, err := strconv.ParseUint(userStr, 10, 64)
+		if err != nil || uid == 0 {
+			return f, fmt.Errorf("Invalid user_id")
+		}
+		f.userID = uint(uid)
+	}
+
+	if minStr := c.Query("min_amount"); minStr != "" {
+		min, err := strconv.ParseFloat(minStr, 64)
+		if err != nil || min < 0 {
+			return f, fmt.Errorf("Invalid min_amount")
+		}
+		f.minAmount = &min
+	}
+	if maxStr := c.Query("max_amount"); maxStr != "" {
+		max, err := strconv.ParseFloat(maxStr, 64)
+		if err != nil || max < 0 {
+			return f, fmt.Errorf("Invalid max_amount")
+		}
+		f.maxAmount = &max
+	}
+	if f.minAmount != nil && f.maxAmount != nil && *f.minAmount > *f.maxAmount {
+		return f, fmt.Errorf("min_amount must not be greater than max_amount")
+	}
+
+	sort, err := parseSort(c.DefaultQuery("sort", "-created_at"), fundsSortFields, fundsIDSortField)
+	if err != nil {
+		return f, err
+	}
+	f.sort = sort
+
+	return f, nil
+}
+
+// apply adds the filters to a query on the funds table.
+// Only funds columns are referenced so it also works on the count queries without the users join.
+func (f fundsListFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.useDateFilter {
+		db = db.Where("funds.created_at >= ? AND funds.created_at < ?", f.startTime, f.endTime)
+	}
+	if !f.rangeStart.IsZero() {
+		db = db.Where("funds.created_at >= ?", f.rangeStart)
+	}
+	if !f.rangeEnd.IsZero() {
+		db = db.Where("funds.created_at < ?", f.rangeEnd)
+	}
+	if len(f.statuses) > 0 {
+		db = db.Where("funds.status IN ?", f.statuses)
+	}
+	if f.block != "" {
+		db = db.Where("funds.block = ?", f.block)
+	}
+	if f.category != "" {
+		db = db.Where("funds.category = ?", f.category)
+	}
+	if f.userID != 0 {
+		db = db.Where("funds.user_id = ?", f.userID)
+	}
+	if f.minAmount != nil {
+		db = db.Where("funds.amount >= ?", *f.minAmount)
+	}
+	if f.maxAmount != nil {
+		db = db.Where("funds.amount <= ?", *f.maxAmount)
+	}
+	if f.search != "" {
+		db = db.Where("funds.description LIKE ?", "%"+escapeLike(f.search)+"%")
+	}
+	return db
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
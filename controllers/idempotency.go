@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyWindow is how long a stored response is replayed for the same Idempotency-Key
+const idempotencyWindow = 24 * time.Hour
+
+// maxIdempotencyKeyLength matches the size of the key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecorder keeps a copy of the response so it can be replayed later
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes a POST endpoint safe to retry. When the request carries an Idempotency-Key
+// header, the first response is stored and returned again for every repeat of the same key by the
+// same user within idempotencyWindow, without running the handler again. Reusing a key with a
+// different body is rejected. Requests without the header are handled as usual.
+// It must run after Authenticate because keys are scoped per user.
+func Idempotency(c *gin.Context) {
+	key := strings.TrimSpace(c.GetHeader("Idempotency-Key"))
+	if key == "" {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.AbortWithStatusJSON(400, gin.H{"message": "Idempotency-Key is too long"})
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.AbortWithStatusJSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+	uid, ok := userID.(uint)
+	if !ok {
+		c.AbortWithStatusJSON(400, gin.H{"message": "Invalid user ID"})
+		return
+	}
+
+	requestHash, err := hashIdempotentRequest(c)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+
+	now := time.Now()
+	var stored models.IdempotencyKey
+	err = initializers.DB.Where("user_id = ? AND `key` = ?", uid, key).First(&stored).Error
+	if err == nil {
+		if stored.Expires_At.After(now) {
+			replayIdempotentResponse(c, stored, requestHash)
+			return
+		}
+		// The window has passed, the key can be used again
+		initializers.DB.Unscoped().Delete(&stored)
+	}
+
+	// Remove expired keys so the table does not grow forever
+	initializers.DB.Unscoped().Where("expires_at < ?", now).Delete(&models.IdempotencyKey{})
+
+	// The unique index on (user_id, key) makes sure only one of two concurrent requests gets through
+	entry := models.IdempotencyKey{
+		Key:          key,
+		User_Id:      uid,
+		Method:       c.Request.Method,
+		Path:         c.FullPath(),
+		Request_Hash: requestHash,
+		Expires_At:   now.Add(idempotencyWindow),
+	}
+	if err := initializers.DB.Create(&entry).Error; err != nil {
+		c.AbortWithStatusJSON(409, gin.H{"message": "A request with this Idempotency-Key is already being processed"})
+		return
+	}
+
+	recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+
+	// The key is released unless a response was stored, also when the handler panics: a key left
+	// processing would answer every retry with 409 for the whole window
+	status := 0
+	defer func() {
+		if status == 0 || status >= 500 {
+			initializers.DB.Unscoped().Delete(&entry)
+		}
+	}()
+	c.Next()
+
+	status = recorder.Status()
+	if status >= 500 {
+		// Let the client retry server errors with the same key
+		return
+	}
+	err = initializers.DB.Model(&entry).Updates(map[string]interface{}{
+		"status_code":  status,
+		"content_type": recorder.Header().Get("Content-Type"),
+		"response":     recorder.body.String(),
+	}).Error
+	if err != nil {
+		status = 0
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, stored models.IdempotencyKey, requestHash string) {
+	if stored.Request_Hash != requestHash {
+		c.AbortWithStatusJSON(422, gin.H{"message": "Idempotency-Key was already used with a different request"})
+		return
+	}
+	if stored.Status_Code == 0 {
+		c.AbortWithStatusJSON(409, gin.H{"message": "A request with this Idempotency-Key is already being processed"})
+		return
+	}
+
+	contentType := stored.Content_Type
+	if contentType == "" {
+		contentType = "application/json; charset=utf-8"
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(stored.Status_Code, contentType, []byte(stored.Response))
+	c.Abort()
+}
+
+// hashIdempotentRequest fingerprints the method, route and body of the request.
+// Multipart bodies are hashed field by field because the boundary changes on every retry.
+// The body is left readable for the handler.
+func hashIdempotentRequest(c *gin.Context) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, c.Request.Method+" "+c.FullPath()+"\n")
+
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return "", err
+		}
+
+		names := make([]string, 0, len(form.Value))
+		for name := range form.Value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range form.Value[name] {
+				io.WriteString(h, "field "+name+"="+v+"\n")
+			}
+		}
+
+		names = names[:0]
+		for name := range form.File {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, fh := range form.File[name] {
+				f, err := fh.Open()
+				if err != nil {
+					return "", err
+				}
+				fileHash := sha256.New()
+				_, err = io.Copy(fileHash, f)
+				f.Close()
+				if err != nil {
+					return "", err
+				}
+				io.WriteString(h, "file "+name+"="+hex.EncodeToString(fileHash.Sum(nil))+"\n")
+			}
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,653 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/events"
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/dontkeep/simaling-backend/notify"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// incidentTypes lists the accepted incident types
+var incidentTypes = map[string]bool{
+	"open_gate":         true,
+	"suspicious_person": true,
+	"fire_hazard":       true,
+	"vandalism":         true,
+	"other":             true,
+}
+
+// incidentSeverities lists the accepted severities
+var incidentSeverities = map[string]bool{
+	"low":      true,
+	"medium":   true,
+	"high":     true,
+	"critical": true,
+}
+
+// incidentTransitions lists the statuses an incident can move to from each status
+var incidentTransitions = map[string][]string{
+	"open":        {"in_progress", "resolved"},
+	"in_progress": {"open", "resolved"},
+	"resolved":    {"open"},
+}
+
+// incidentOrder lists incidents newest first, the id keeps cursors unique
+var incidentOrder = sortOrder{
+	{field: sortField{Key: "created_at", Column: "incidents.created_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "incidents.id"}, desc: true},
+}
+
+// incidentSelect selects the columns of incidentResponse
+const incidentSelect = "incidents.id, incidents.type, incidents.severity, incidents.title, incidents.description, incidents.block, " +
+	"incidents.latitude, incidents.longitude, incidents.status, incidents.visible_to_residents, incidents.reporter_id, " +
+	"users.name as reporter_name, incidents.resolved_at, incidents.created_at, incidents.updated_at"
+
+type incidentResponse struct {
+	ID                 uint       `json:"id"`
+	Type               string     `json:"type"`
+	Severity           string     `json:"severity"`
+	Title              string     `json:"title"`
+	Description        string     `json:"description"`
+	Block              string     `json:"block"`
+	Latitude           *float64   `json:"latitude"`
+	Longitude          *float64   `json:"longitude"`
+	Status             string     `json:"status"`
+	VisibleToResidents bool       `json:"visible_to_residents"`
+	ReporterId         uint       `json:"reporter_id"`
+	ReporterName       string     `json:"reporter_name"`
+	ResolvedAt         *time.Time `json:"resolved_at"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+type incidentAssigneeResponse struct {
+	UserId uint   `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+type incidentCommentResponse struct {
+	ID        uint      `json:"id"`
+	UserId    uint      `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// incidentDetailResponse adds the photos to an incident, and for the staff the assignees and comments
+type incidentDetailResponse struct {
+	incidentResponse
+	Photos    []incidentPhotoResponse    `json:"photos"`
+	Assignees []incidentAssigneeResponse `json:"assignees,omitempty"`
+	Comments  []incidentCommentResponse  `json:"comments,omitempty"`
+}
+
+func incidentQuery() *gorm.DB {
+	return initializers.DB.Model(&models.Incident{}).
+		Select(incidentSelect).
+		Joins("left join users on users.id = incidents.reporter_id")
+}
+
+// staffOrResident loads the authenticated user and tells if it is staff (an admin or a guard),
+// anyone else but a resident (normal user) is refused
+func staffOrResident(c *gin.Context) (models.User, bool, bool) {
+	var user models.User
+	uid, ok := currentUserID(c)
+	if !ok || initializers.DB.First(&user, uid).Error != nil {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return user, false, false
+	}
+	staff := user.Role_Id == 1 || user.Role_Id == 3
+	if !staff && user.Role_Id != 2 {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return user, false, false
+	}
+	return user, staff, true
+}
+
+// residentIncidents limits a query to the incidents a resident may see
+func residentIncidents(db *gorm.DB, user models.User) *gorm.DB {
+	return db.Where("incidents.visible_to_residents = ? AND incidents.block = ? AND incidents.block <> ''", true, user.Block)
+}
+
+// findIncident loads the incident in the URL, responding 404 when it does not exist or the user may not see it
+func findIncident(c *gin.Context, user models.User, staff bool) (*models.Incident, bool) {
+	db := initializers.DB.Where("incidents.id = ?", c.Param("id"))
+	if !staff {
+		db = residentIncidents(db, user)
+	}
+	var incident models.Incident
+	if err := db.First(&incident).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Incident not found"})
+		return nil, false
+	}
+	return &incident, true
+}
+
+func getIncidentDetail(id uint, staff bool) (incidentDetailResponse, error) {
+	var detail incidentDetailResponse
+	if err := incidentQuery().Where("incidents.id = ?", id).Scan(&detail.incidentResponse).Error; err != nil {
+		return detail, err
+	}
+	photos, err := incidentPhotos(id)
+	if err != nil {
+		return detail, err
+	}
+	detail.Photos = photos
+	if !staff {
+		return detail, nil
+	}
+
+	err = initializers.DB.Model(&models.IncidentAssignee{}).
+		Select("incident_assignees.user_id, users.name").
+		Joins("left join users on users.id = incident_assignees.user_id").
+		Where("incident_assignees.incident_id = ?", id).
+		Order("users.name ASC").
+		Scan(&detail.Assignees).Error
+	if err != nil {
+		return detail, err
+	}
+	err = initializers.DB.Model(&models.IncidentComment{}).
+		Select("incident_comments.id, incident_comments.user_id, users.name as user_name, incident_comments.body, incident_comments.created_at").
+		Joins("left join users on users.id = incident_comments.user_id").
+		Where("incident_comments.incident_id = ?", id).
+		Order("incident_comments.created_at ASC, incident_comments.id ASC").
+		Scan(&detail.Comments).Error
+	return detail, err
+}
+
+// incidentAudience sends incident events to the staff, and to the residents of the block when they may see it
+func incidentAudience(incident models.Incident) events.Audience {
+	audience := staffAudience()
+	if incident.Visible_To_Residents {
+		audience.BlockRoles = []uint{2}
+		audience.Block = incident.Block
+	}
+	return audience
+}
+
+// notifyIncident sends a notification about an incident without holding up the request
+func notifyIncident(incident models.Incident, title string, recipients []notify.Recipient) {
+	if len(recipients) == 0 {
+		return
+	}
+	msg := notify.Message{
+		Event:      "incident",
+		Title:      title,
+		Body:       fmt.Sprintf("[%s] %s, block %s: %s", incident.Severity, incident.Type, incident.Block, incident.Title),
+		Recipients: recipients,
+		Data:       map[string]interface{}{"incident_id": incident.ID, "status": incident.Status},
+	}
+	go initializers.Notifier.Send(context.Background(), msg)
+}
+
+// GetIncidents lists incidents, filtered with ?status=, ?type=, ?severity=, ?block= and ?assigned=me (staff).
+// Residents only get the incidents of their own block.
+func GetIncidents(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+
+	pagination, err := parsePagination(c, incidentOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if !staff {
+			db = residentIncidents(db, user)
+		}
+		for _, field := range []string{"status", "type", "severity", "block"} {
+			if value := c.Query(field); value != "" {
+				db = db.Where("incidents."+field+" = ?", value)
+			}
+		}
+		if staff && c.Query("assigned") == "me" {
+			db = db.Where("EXISTS (SELECT 1 FROM incident_assignees WHERE incident_assignees.incident_id = incidents.id AND incident_assignees.user_id = ? AND incident_assignees.deleted_at IS NULL)", user.ID)
+		}
+		return db
+	}
+
+	var incidents []incidentResponse
+	if err := pagination.apply(filter(incidentQuery())).Scan(&incidents).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get incidents"})
+		return
+	}
+
+	c.JSON(200, paginate(pagination, incidents, func() int64 {
+		var total int64
+		filter(initializers.DB.Model(&models.Incident{})).Count(&total)
+		return total
+	}))
+}
+
+// GetIncidentById gets an incident with its photos, staff also get the assignees and comments
+func GetIncidentById(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	detail, err := getIncidentDetail(incident.ID, staff)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get incident"})
+		return
+	}
+	c.JSON(200, gin.H{"data": detail})
+}
+
+// parseIncidentLocation reads the optional latitude and longitude form fields
+func parseIncidentLocation(c *gin.Context) (*float64, *float64, bool) {
+	latStr, lngStr := c.PostForm("latitude"), c.PostForm("longitude")
+	if latStr == "" && lngStr == "" {
+		return nil, nil, true
+	}
+	lat, err1 := strconv.ParseFloat(latStr, 64)
+	lng, err2 := strconv.ParseFloat(lngStr, 64)
+	if err1 != nil || err2 != nil || !validCoordinates(lat, lng) {
+		c.JSON(400, gin.H{"message": "Invalid coordinates"})
+		return nil, nil, false
+	}
+	return &lat, &lng, true
+}
+
+// CreateIncident reports an incident (multipart form): type, severity (default medium), title, description,
+// block, latitude, longitude, visible_to_residents (default true) and any number of "photos" files.
+// The admins are notified, and the guards on duty too for high and critical incidents.
+func CreateIncident(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	incident := models.Incident{
+		Reporter_Id: user.ID,
+		Type:        c.PostForm("type"),
+		Severity:    c.DefaultPostForm("severity", "medium"),
+		Title:       c.PostForm("title"),
+		Description: c.PostForm("description"),
+		Block:       c.PostForm("block"),
+		Status:      "open",
+	}
+	if !incidentTypes[incident.Type] {
+		c.JSON(400, gin.H{"message": "Invalid incident type"})
+		return
+	}
+	if !incidentSeverities[incident.Severity] {
+		c.JSON(400, gin.H{"message": "Invalid severity"})
+		return
+	}
+	if incident.Title == "" {
+		c.JSON(400, gin.H{"message": "Title is required"})
+		return
+	}
+	visible, err := strconv.ParseBool(c.DefaultPostForm("visible_to_residents", "true"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": "Invalid visible_to_residents value"})
+		return
+	}
+	incident.Visible_To_Residents = visible
+	latitude, longitude, ok := parseIncidentLocation(c)
+	if !ok {
+		return
+	}
+	incident.Latitude, incident.Longitude = latitude, longitude
+
+	var files []*multipart.FileHeader
+	if form, err := c.MultipartForm(); err == nil {
+		files = form.File["photos"]
+	}
+	for _, file := range files {
+		key, thumbnailKey, err := saveUploadedImage(c, file, "incidents")
+		if err != nil {
+			for _, photo := range incident.Photos {
+				deleteUploadedImage(c, photo.Storage_Key, photo.Thumbnail)
+			}
+			if message := uploadErrorMessage(err); message != "" {
+				c.JSON(400, gin.H{"message": message})
+				return
+			}
+			c.JSON(500, gin.H{"message": "Failed to save photo"})
+			return
+		}
+		incident.Photos = append(incident.Photos, models.IncidentPhoto{Storage_Key: key, Thumbnail: thumbnailKey})
+	}
+
+	if err := initializers.DB.Create(&incident).Error; err != nil {
+		for _, photo := range incident.Photos {
+			deleteUploadedImage(c, photo.Storage_Key, photo.Thumbnail)
+		}
+		c.JSON(500, gin.H{"message": "Failed to create incident"})
+		return
+	}
+
+	recipients := adminRecipients()
+	if incident.Severity == "high" || incident.Severity == "critical" {
+		if onDuty, err := onDutyAssignments(time.Now()); err == nil {
+			recipients = patrolAlertRecipients(onDuty)
+		}
+	}
+	notifyIncident(incident, "New incident", recipients)
+
+	detail, _ := getIncidentDetail(incident.ID, true)
+	publishEvent("incident.created", detail.incidentResponse, incidentAudience(incident))
+	c.JSON(200, gin.H{
+		"message": "Incident reported successfully",
+		"data":    detail,
+	})
+}
+
+// UpdateIncident changes the fields present in the JSON body, the status has its own endpoint
+func UpdateIncident(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Type               *string  `json:"type"`
+		Severity           *string  `json:"severity"`
+		Title              *string  `json:"title"`
+		Description        *string  `json:"description"`
+		Block              *string  `json:"block"`
+		Latitude           *float64 `json:"latitude"`
+		Longitude          *float64 `json:"longitude"`
+		VisibleToResidents *bool    `json:"visible_to_residents"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.Type != nil {
+		if !incidentTypes[*body.Type] {
+			c.JSON(400, gin.H{"message": "Invalid incident type"})
+			return
+		}
+		incident.Type = *body.Type
+	}
+	if body.Severity != nil {
+		if !incidentSeverities[*body.Severity] {
+			c.JSON(400, gin.H{"message": "Invalid severity"})
+			return
+		}
+		incident.Severity = *body.Severity
+	}
+	if body.Title != nil {
+		if *body.Title == "" {
+			c.JSON(400, gin.H{"message": "Title is required"})
+			return
+		}
+		incident.Title = *body.Title
+	}
+	if body.Description != nil {
+		incident.Description = *body.Description
+	}
+	if body.Block != nil {
+		incident.Block = *body.Block
+	}
+	if (body.Latitude == nil) != (body.Longitude == nil) {
+		c.JSON(400, gin.H{"message": "Send both latitude and longitude"})
+		return
+	}
+	if body.Latitude != nil {
+		if !validCoordinates(*body.Latitude, *body.Longitude) {
+			c.JSON(400, gin.H{"message": "Invalid coordinates"})
+			return
+		}
+		incident.Latitude, incident.Longitude = body.Latitude, body.Longitude
+	}
+	if body.VisibleToResidents != nil {
+		incident.Visible_To_Residents = *body.VisibleToResidents
+	}
+
+	if err := initializers.DB.Save(incident).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update incident"})
+		return
+	}
+
+	detail, _ := getIncidentDetail(incident.ID, true)
+	publishEvent("incident.updated", detail.incidentResponse, incidentAudience(*incident))
+	c.JSON(200, gin.H{
+		"message": "Incident updated",
+		"data":    detail,
+	})
+}
+
+// UpdateIncidentStatus moves an incident through its lifecycle, {"status": "resolved", "comment": "..."}.
+// The change is logged as a comment and the reporter and assignees are notified.
+func UpdateIncidentStatus(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Status  string `json:"status"`
+		Comment string `json:"comment"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	allowed := false
+	for _, status := range incidentTransitions[incident.Status] {
+		allowed = allowed || status == body.Status
+	}
+	if !allowed {
+		c.JSON(400, gin.H{"message": fmt.Sprintf("Cannot change status from %s to %s", incident.Status, body.Status)})
+		return
+	}
+
+	comment := fmt.Sprintf("Status changed from %s to %s", incident.Status, body.Status)
+	if body.Comment != "" {
+		comment += ": " + body.Comment
+	}
+	updates := map[string]interface{}{"status": body.Status, "resolved_at": nil}
+	if body.Status == "resolved" {
+		updates["resolved_at"] = time.Now()
+	}
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(incident).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.IncidentComment{Incident_Id: incident.ID, User_Id: user.ID, Body: comment}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update incident status"})
+		return
+	}
+
+	var recipients []models.User
+	initializers.DB.Select("id, name, phone_no").
+		Where("id <> ? AND (id = ? OR id IN (SELECT user_id FROM incident_assignees WHERE incident_id = ? AND deleted_at IS NULL))", user.ID, incident.Reporter_Id, incident.ID).
+		Find(&recipients)
+	notifyIncident(*incident, "Incident "+body.Status, userRecipients(recipients))
+
+	detail, _ := getIncidentDetail(incident.ID, true)
+	publishEvent("incident.status_changed", detail.incidentResponse, incidentAudience(*incident))
+	c.JSON(200, gin.H{
+		"message": "Incident status updated",
+		"data":    detail,
+	})
+}
+
+func userRecipients(users []models.User) []notify.Recipient {
+	recipients := make([]notify.Recipient, len(users))
+	for i, u := range users {
+		recipients[i] = notify.Recipient{UserID: u.ID, Name: u.Name, Phone: u.Phone_No}
+	}
+	return recipients
+}
+
+// SetIncidentAssignees replaces the assignees of an incident with the admins or guards in user_ids,
+// newly assigned users are notified
+func SetIncidentAssignees(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if user.Role_Id != 1 {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		UserIds []uint `json:"user_ids"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	seen := map[uint]bool{}
+	var ids []uint
+	for _, id := range body.UserIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	var assignees []models.User
+	if len(ids) > 0 {
+		initializers.DB.Select("id, name, phone_no").Where("id IN ? AND role_id IN ?", ids, []uint{1, 3}).Find(&assignees)
+		if len(assignees) != len(ids) {
+			c.JSON(400, gin.H{"message": "user_ids must be admins or security users"})
+			return
+		}
+	}
+
+	var previous []uint
+	initializers.DB.Model(&models.IncidentAssignee{}).Where("incident_id = ?", incident.ID).Pluck("user_id", &previous)
+	wasAssigned := map[uint]bool{}
+	for _, id := range previous {
+		wasAssigned[id] = true
+	}
+
+	// Hard delete so a user can be assigned again without breaking the unique index
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("incident_id = ?", incident.ID).Delete(&models.IncidentAssignee{}).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := tx.Create(&models.IncidentAssignee{Incident_Id: incident.ID, User_Id: id}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to assign incident"})
+		return
+	}
+
+	var added []models.User
+	for _, assignee := range assignees {
+		if !wasAssigned[assignee.ID] && assignee.ID != user.ID {
+			added = append(added, assignee)
+		}
+	}
+	notifyIncident(*incident, "Incident assigned to you", userRecipients(added))
+
+	detail, _ := getIncidentDetail(incident.ID, true)
+	c.JSON(200, gin.H{
+		"message": "Incident assignees updated",
+		"data":    detail,
+	})
+}
+
+// AddIncidentComment adds a note of the staff to an incident
+func AddIncidentComment(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.Body == "" {
+		c.JSON(400, gin.H{"message": "Comment body is required"})
+		return
+	}
+
+	comment := models.IncidentComment{Incident_Id: incident.ID, User_Id: user.ID, Body: body.Body}
+	if err := initializers.DB.Create(&comment).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to add comment"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Comment added",
+		"data": incidentCommentResponse{
+			ID:        comment.ID,
+			UserId:    user.ID,
+			UserName:  user.Name,
+			Body:      comment.Body,
+			CreatedAt: comment.CreatedAt,
+		},
+	})
+}
+
+// DeleteIncident removes an incident
+func DeleteIncident(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var incident models.Incident
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&incident).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Incident not found"})
+		return
+	}
+	if err := initializers.DB.Delete(&incident).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete incident"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Incident deleted"})
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+type incidentPhotoResponse struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Thumbnail string    `json:"thumbnail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toIncidentPhotoResponse(p models.IncidentPhoto) incidentPhotoResponse {
+	return incidentPhotoResponse{
+		ID:        p.ID,
+		URL:       getFullImageURL(p.Storage_Key),
+		Thumbnail: getFullImageURL(p.Thumbnail),
+		CreatedAt: p.CreatedAt,
+	}
+}
+
+// incidentPhotos returns the photos of an incident in upload order
+func incidentPhotos(incidentID uint) ([]incidentPhotoResponse, error) {
+	var photos []models.IncidentPhoto
+	if err := initializers.DB.Where("incident_id = ?", incidentID).Order("id ASC").Find(&photos).Error; err != nil {
+		return nil, err
+	}
+	response := make([]incidentPhotoResponse, len(photos))
+	for i, p := range photos {
+		response[i] = toIncidentPhotoResponse(p)
+	}
+	return response, nil
+}
+
+// AddIncidentPhoto uploads a photo (form field "file") to an incident
+func AddIncidentPhoto(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"message": "File is required"})
+		return
+	}
+	key, thumbnailKey, err := saveUploadedImage(c, file, "incidents")
+	if err != nil {
+		if message := uploadErrorMessage(err); message != "" {
+			c.JSON(400, gin.H{"message": message})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to save photo"})
+		return
+	}
+
+	photo := models.IncidentPhoto{Incident_Id: incident.ID, Storage_Key: key, Thumbnail: thumbnailKey}
+	if err := initializers.DB.Create(&photo).Error; err != nil {
+		deleteUploadedImage(c, key, thumbnailKey)
+		c.JSON(500, gin.H{"message": "Failed to save photo"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Photo added",
+		"data":    toIncidentPhotoResponse(photo),
+	})
+}
+
+// DeleteIncidentPhoto removes a photo, allowed for admins and the reporter of the incident
+func DeleteIncidentPhoto(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	incident, ok := findIncident(c, user, staff)
+	if !ok {
+		return
+	}
+	if user.Role_Id != 1 && incident.Reporter_Id != user.ID {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+
+	var photo models.IncidentPhoto
+	if err := initializers.DB.Where("id = ? AND incident_id = ?", c.Param("photoId"), incident.ID).First(&photo).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Photo not found"})
+		return
+	}
+	if err := initializers.DB.Delete(&photo).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete photo"})
+		return
+	}
+
+	// The row is gone, a file that fails to delete here is left for the cleanup job
+	deleteUploadedImage(c, photo.Storage_Key, photo.Thumbnail)
+
+	c.JSON(200, gin.H{"message": "Photo deleted"})
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxPageLimit caps the limit query parameter of every paginated list
+const maxPageLimit = 100
+
+// sortField maps a sort key accepted from clients to its SQL column.
+// Key is also the JSON name of the field in the response rows, which is where cursors read their values from.
+type sortField struct {
+	Key    string
+	Column string
+	IsTime bool
+}
+
+type sortKey struct {
+	field sortField
+	desc  bool
+}
+
+// sortOrder is an ORDER BY made of whitelisted columns, always ending with a unique tie-breaker
+type sortOrder []sortKey
+
+// parseSort turns "-amount,created_at" into a sortOrder using only whitelisted fields.
+// The tie-breaker keeps the order stable between pages and is what makes keyset cursors unique.
+func parseSort(sort string, fields map[string]sortField, tieBreaker sortField) (sortOrder, error) {
+	var order sortOrder
+	seenTieBreaker := false
+	for _, name := range strings.Split(sort, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := false
+		if strings.HasPrefix(name, "-") {
+			desc = true
+			name = name[1:]
+		} else if strings.HasPrefix(name, "+") {
+			name = name[1:]
+		}
+		field, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("Invalid sort field: %s", name)
+		}
+		if field.Column == tieBreaker.Column {
+			seenTieBreaker = true
+		}
+		order = append(order, sortKey{field: field, desc: desc})
+	}
+	if !seenTieBreaker {
+		// Follow the direction of the first key so "-created_at" lists the newest id first
+		desc := len(order) == 0 || order[0].desc
+		order = append(order, sortKey{field: tieBreaker, desc: desc})
+	}
+	return order, nil
+}
+
+// clause renders the ORDER BY clause, reversed when walking backwards from a cursor
+func (o sortOrder) clause(reverse bool) string {
+	clauses := make([]string, len(o))
+	for i, k := range o {
+		direction := "ASC"
+		if k.desc != reverse {
+			direction = "DESC"
+		}
+		clauses[i] = k.field.Column + " " + direction
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// signature identifies the sort a cursor was created for
+func (o sortOrder) signature() string {
+	return o.clause(false)
+}
+
+// keyset builds the WHERE condition selecting rows after (or before) the given values
+func (o sortOrder) keyset(values []interface{}, backward bool) (string, []interface{}) {
+	var ors []string
+	var args []interface{}
+	for i, k := range o {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, o[j].field.Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if k.desc != backward {
+			op = "<"
+		}
+		ands = append(ands, k.field.Column+" "+op+" ?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return strings.Join(ors, " OR "), args
+}
+
+// pageCursor is the decoded form of the opaque next_cursor/prev_cursor strings
+type pageCursor struct {
+	Values   []interface{} `json:"v"`
+	Backward bool          `json:"b,omitempty"`
+	Sort     string        `json:"s"`
+}
+
+func encodeCursor(cur pageCursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, order sortOrder) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	var cur pageCursor
+	if err := json.Unmarshal(raw, &cur); err != nil || len(cur.Values) != len(order) {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+	if cur.Sort != order.signature() {
+		return nil, fmt.Errorf("Cursor does not match the requested sort")
+	}
+	// JSON turned the timestamps into strings, convert them back for the query
+	for i, k := range order {
+		if !k.field.IsTime {
+			continue
+		}
+		str, ok := cur.Values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid cursor")
+		}
+		t, err := time.Parse(time.RFC3339Nano, str)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor")
+		}
+		cur.Values[i] = t
+	}
+	return &cur, nil
+}
+
+// listPagination holds the page/limit or cursor parameters of a list request.
+//
+//	page, limit  classic offset pagination (limit is capped at maxPageLimit)
+//	cursor       keyset pagination, pass an empty cursor for the first page then next_cursor/prev_cursor
+//	count=false  skip the total count query
+type listPagination struct {
+	page       int
+	limit      int
+	withTotal  bool
+	cursorMode bool
+	cursor     *pageCursor
+	order      sortOrder
+}
+
+func parsePagination(c *gin.Context, order sortOrder) (listPagination, error) {
+	p := listPagination{order: order, withTotal: true}
+
+	pageInt, err := strconv.Atoi(c.DefaultQuery("page", "1")) // Default to page 1 if not provided
+	if err != nil || pageInt < 1 {
+		return p, fmt.Errorf("Invalid page number")
+	}
+	p.page = pageInt
+
+	limitInt, err := strconv.Atoi(c.DefaultQuery("limit", "10")) // Default to 10 records per page if not provided
+	if err != nil || limitInt < 1 {
+		return p, fmt.Errorf("Invalid limit number")
+	}
+	if limitInt > maxPageLimit {
+		limitInt = maxPageLimit
+	}
+	p.limit = limitInt
+
+	if countStr := c.Query("count"); countStr != "" {
+		withTotal, err := strconv.ParseBool(countStr)
+		if err != nil {
+			return p, fmt.Errorf("Invalid count value")
+		}
+		p.withTotal = withTotal
+	}
+
+	if cursorStr, ok := c.GetQuery("cursor"); ok {
+		p.cursorMode = true
+		if cursorStr != "" {
+			cur, err := decodeCursor(cursorStr, order)
+			if err != nil {
+				return p, err
+			}
+			p.cursor = cur
+		}
+	}
+
+	return p, nil
+}
+
+// apply adds ordering and either offset or keyset pagination to the query.
+// In cursor mode one extra row is fetched to know whether another page exists.
+func (p listPagination) apply(db *gorm.DB) *gorm.DB {
+	if !p.cursorMode {
+		return db.Order(p.order.clause(false)).Limit(p.limit).Offset((p.page - 1) * p.limit)
+	}
+
+	backward := p.cursor != nil && p.cursor.Backward
+	if p.cursor != nil {
+		where, args := p.order.keyset(p.cursor.Values, backward)
+		db = db.Where(where, args...)
+	}
+	return db.Order(p.order.clause(backward)).Limit(p.limit + 1)
+}
+
+// paginate trims the rows fetched by apply and builds the response with the pagination fields.
+// The total is only added when counting was not turned off, count is called lazily for that reason.
+func paginate[T any](p listPagination, rows []T, count func() int64) gin.H {
+	response := gin.H{"limit": p.limit}
+
+	if !p.cursorMode {
+		response["page"] = p.page
+	} else {
+		backward := p.cursor != nil && p.cursor.Backward
+		hasMore := len(rows) > p.limit
+		if hasMore {
+			rows = rows[:p.limit]
+		}
+		if backward {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+
+		var nextCursor, prevCursor string
+		if len(rows) > 0 {
+			if hasMore || backward {
+				nextCursor = p.cursorFor(rows[len(rows)-1], false)
+			}
+			if (backward && hasMore) || (!backward && p.cursor != nil) {
+				prevCursor = p.cursorFor(rows[0], true)
+			}
+		}
+		response["next_cursor"] = nextCursor
+		response["prev_cursor"] = prevCursor
+	}
+
+	if p.withTotal {
+		total := count()
+		response["total"] = total
+		if !p.cursorMode {
+			response["totalPages"] = (total + int64(p.limit) - 1) / int64(p.limit) // Calculate total pages
+		}
+	}
+
+	if rows == nil {
+		rows = []T{}
+	}
+	response["data"] = rows
+	return response
+}
+
+// cursorFor reads the sort values out of a response row through its JSON field names
+func (p listPagination) cursorFor(row interface{}, backward bool) string {
+	raw, err := json.Marshal(row)
+	if err != nil {
+		return ""
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return ""
+	}
+	values := make([]interface{}, len(p.order))
+	for i, k := range p.order {
+		values[i] = fields[k.field.Key]
+	}
+	return encodeCursor(pageCursor{Values: values, Backward: backward, Sort: p.order.signature()})
+}
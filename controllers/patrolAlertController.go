@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// patrolAlertOrder lists alerts newest first, the id keeps cursors unique
+var patrolAlertOrder = sortOrder{
+	{field: sortField{Key: "created_at", Column: "patrol_alerts.created_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "patrol_alerts.id"}, desc: true},
+}
+
+type patrolAlertResponse struct {
+	ID             uint       `json:"id"`
+	Type           string     `json:"type"`
+	SecurityId     *uint      `json:"security_id"`
+	SecurityName   *string    `json:"security_name"`
+	ShiftId        *uint      `json:"shift_id"`
+	RouteId        *uint      `json:"route_id"`
+	Round          int        `json:"round"`
+	Message        string     `json:"message"`
+	Status         string     `json:"status"`
+	EscalatedAt    *time.Time `json:"escalated_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	AcknowledgedBy *uint      `json:"acknowledged_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func patrolAlertQuery() *gorm.DB {
+	return initializers.DB.Model(&models.PatrolAlert{}).
+		Select("patrol_alerts.id, patrol_alerts.type, patrol_alerts.security_id, users.name as security_name, patrol_alerts.shift_id, " +
+			"patrol_alerts.route_id, patrol_alerts.round, patrol_alerts.message, patrol_alerts.status, patrol_alerts.escalated_at, " +
+			"patrol_alerts.acknowledged_at, patrol_alerts.acknowledged_by, patrol_alerts.created_at").
+		Joins("left join users on users.id = patrol_alerts.security_id")
+}
+
+// GetPatrolAlerts lists the alerts raised by the patrol monitor, ?status= and ?type= filter the list
+func GetPatrolAlerts(c *gin.Context) {
+	if !isAdmin(c) && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	pagination, err := parsePagination(c, patrolAlertOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if status := c.Query("status"); status != "" {
+			db = db.Where("patrol_alerts.status = ?", status)
+		}
+		if alertType := c.Query("type"); alertType != "" {
+			db = db.Where("patrol_alerts.type = ?", alertType)
+		}
+		return db
+	}
+
+	var alerts []patrolAlertResponse
+	if err := pagination.apply(filter(patrolAlertQuery())).Scan(&alerts).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get patrol alerts"})
+		return
+	}
+
+	c.JSON(200, paginate(pagination, alerts, func() int64 {
+		var total int64
+		filter(initializers.DB.Model(&models.PatrolAlert{})).Count(&total)
+		return total
+	}))
+}
+
+// AcknowledgePatrolAlert marks an open alert as seen, which stops its escalation.
+// Admins and the other guards can acknowledge an inactivity alert, the guard it is about cannot silence it.
+// A missed round has no single guard, so only admins can acknowledge it.
+func AcknowledgePatrolAlert(c *gin.Context) {
+	admin := isAdmin(c)
+	if !admin && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	updatePatrolAlertStatus(c, admin, []string{"Open"}, "Acknowledged")
+}
+
+// ResolvePatrolAlert closes an alert
+func ResolvePatrolAlert(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+	updatePatrolAlertStatus(c, true, []string{"Open", "Acknowledged"}, "Resolved")
+}
+
+func updatePatrolAlertStatus(c *gin.Context, admin bool, from []string, to string) {
+	var alert models.PatrolAlert
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&alert).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Patrol alert not found"})
+		return
+	}
+
+	uid, _ := currentUserID(c)
+	if alert.Security_Id != nil && *alert.Security_Id == uid {
+		c.JSON(403, gin.H{"message": "Forbidden: the alert is about you"})
+		return
+	}
+	if alert.Type == patrolAlertMissed && !admin {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only for missed patrols"})
+		return
+	}
+
+	allowed := false
+	for _, status := range from {
+		allowed = allowed || alert.Status == status
+	}
+	if !allowed {
+		c.JSON(409, gin.H{"message": "Patrol alert is " + alert.Status})
+		return
+	}
+
+	updates := map[string]interface{}{"status": to}
+	if alert.Acknowledged_At == nil {
+		// Resolving an open alert also counts as acknowledging it
+		updates["acknowledged_at"] = time.Now()
+		updates["acknowledged_by"] = uid
+	}
+	if err := initializers.DB.Model(&alert).Updates(updates).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update patrol alert"})
+		return
+	}
+
+	var response patrolAlertResponse
+	patrolAlertQuery().Where("patrol_alerts.id = ?", alert.ID).Scan(&response)
+	c.JSON(200, gin.H{
+		"message": "Patrol alert " + to,
+		"data":    response,
+	})
+}
@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Status of a stop or a round of a patrol
+const (
+	patrolVisited    = "visited"
+	patrolLate       = "late"
+	patrolMissed     = "missed"
+	patrolPending    = "pending"  // the round is running and the stop was not visited yet
+	patrolUpcoming   = "upcoming" // the round has not started
+	patrolCompleted  = "completed"
+	patrolInProgress = "in_progress"
+)
+
+// patrolVisit is a security record as far as the schedule is concerned
+type patrolVisit struct {
+	ID               uint
+	Security_Id      uint
+	Checkpoint_Id    *uint
+	Block            string
+	Outside_Geofence bool
+	Visited_At       time.Time
+}
+
+type patrolStopResult struct {
+	Position       int        `json:"position"`
+	CheckpointId   *uint      `json:"checkpoint_id"`
+	CheckpointName string     `json:"checkpoint_name"`
+	Block          string     `json:"block"`
+	Status         string     `json:"status"`
+	VisitedAt      *time.Time `json:"visited_at"`
+	RecordId       *uint      `json:"record_id"`
+	SecurityId     *uint      `json:"security_id"`
+}
+
+type patrolRoundResult struct {
+	Round  int                `json:"round"`
+	Start  time.Time          `json:"start"`
+	End    time.Time          `json:"end"`
+	Status string             `json:"status"`
+	Stops  []patrolStopResult `json:"stops"`
+}
+
+type patrolSummary struct {
+	Rounds          int     `json:"rounds"`
+	RoundsCompleted int     `json:"rounds_completed"`
+	RoundsLate      int     `json:"rounds_late"`
+	RoundsMissed    int     `json:"rounds_missed"`
+	StopsVisited    int     `json:"stops_visited"`
+	StopsLate       int     `json:"stops_late"`
+	StopsMissed     int     `json:"stops_missed"`
+	Compliance      float64 `json:"compliance"` // Percentage of the stops of finished rounds visited on time
+}
+
+// patrolRoundStarts returns the start of every round of the route in the night starting on the given date.
+// The last round ends at start + interval, which may be after End_Time.
+func patrolRoundStarts(route models.PatrolRoute, night time.Time) []time.Time {
+	start, err := parseClock(route.Start_Time)
+	if err != nil {
+		return nil
+	}
+	end, err := parseClock(route.End_Time)
+	if err != nil {
+		return nil
+	}
+	if end <= start {
+		end += 24 * 60
+	}
+
+	day := time.Date(night.Year(), night.Month(), night.Day(), 0, 0, 0, 0, jakartaLocation)
+	var starts []time.Time
+	for minute := start; minute < end; minute += route.Interval_Minutes {
+		starts = append(starts, day.Add(time.Duration(minute)*time.Minute))
+	}
+	return starts
+}
+
+// patrolNight returns the night a moment belongs to, before noon is still the previous night
+func patrolNight(t time.Time) time.Time {
+	t = t.In(jakartaLocation)
+	if t.Hour() < 12 {
+		t = t.AddDate(0, 0, -1)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, jakartaLocation)
+}
+
+// patrolStopMatches checks if a visit is at the checkpoint, or for a block stop in the block, of the stop.
+// Records get the nearest checkpoint at any distance, so a checkpoint stop needs a record inside its geofence.
+func patrolStopMatches(stop models.PatrolRouteStop, v patrolVisit) bool {
+	if stop.Checkpoint_Id != nil {
+		return !v.Outside_Geofence && v.Checkpoint_Id != nil && *v.Checkpoint_Id == *stop.Checkpoint_Id
+	}
+	return v.Block == stop.Block
+}
+
+// evaluatePatrol compares the visits (sorted by time) with the rounds of the route.
+// Each stop of a round is satisfied by the first matching visit inside the round,
+// visits later than Grace_Minutes after the start of the round are late.
+func evaluatePatrol(route models.PatrolRoute, night time.Time, visits []patrolVisit, now time.Time) ([]patrolRoundResult, patrolSummary) {
+	interval := time.Duration(route.Interval_Minutes) * time.Minute
+	grace := time.Duration(route.Grace_Minutes) * time.Minute
+
+	var summary patrolSummary
+	var finishedStops int
+	starts := patrolRoundStarts(route, night)
+	rounds := make([]patrolRoundResult, len(starts))
+	for i, start := range starts {
+		end := start.Add(interval)
+		round := patrolRoundResult{Round: i + 1, Start: start, End: end, Stops: make([]patrolStopResult, len(route.Stops))}
+		finished := !now.Before(end)
+
+		var visited, late, missed int
+		for j, stop := range route.Stops {
+			result := patrolStopResult{
+				Position:       stop.Position,
+				CheckpointId:   stop.Checkpoint_Id,
+				CheckpointName: stop.Checkpoint.Name,
+				Block:          stop.Block,
+			}
+			for k := range visits {
+				v := &visits[k]
+				if v.Visited_At.Before(start) || !v.Visited_At.Before(end) || !patrolStopMatches(stop, *v) {
+					continue
+				}
+				result.VisitedAt = &v.Visited_At
+				result.RecordId = &v.ID
+				result.SecurityId = &v.Security_Id
+				break
+			}
+
+			switch {
+			case result.VisitedAt != nil && result.VisitedAt.After(start.Add(grace)):
+				result.Status = patrolLate
+				late++
+			case result.VisitedAt != nil:
+				result.Status = patrolVisited
+				visited++
+			case finished:
+				result.Status = patrolMissed
+				missed++
+			case now.Before(start):
+				result.Status = patrolUpcoming
+			default:
+				result.Status = patrolPending
+			}
+			round.Stops[j] = result
+		}
+
+		switch {
+		case now.Before(start):
+			round.Status = patrolUpcoming
+		case missed > 0:
+			round.Status = patrolMissed
+		case late > 0 && visited+late == len(route.Stops):
+			round.Status = patrolLate
+		case visited == len(route.Stops):
+			round.Status = patrolCompleted
+		default:
+			round.Status = patrolInProgress
+		}
+
+		summary.Rounds++
+		switch round.Status {
+		case patrolCompleted:
+			summary.RoundsCompleted++
+		case patrolLate:
+			summary.RoundsLate++
+		case patrolMissed:
+			summary.RoundsMissed++
+		}
+		summary.StopsVisited += visited
+		summary.StopsLate += late
+		summary.StopsMissed += missed
+		if finished {
+			finishedStops += len(route.Stops)
+		}
+		rounds[i] = round
+	}
+
+	if finishedStops > 0 {
+		onTime := 0
+		for _, round := range rounds {
+			if !now.Before(round.End) {
+				for _, stop := range round.Stops {
+					if stop.Status == patrolVisited {
+						onTime++
+					}
+				}
+			}
+		}
+		summary.Compliance = float64(onTime*1000/finishedStops) / 10
+	}
+	return rounds, summary
+}
+
+// securityRecordVisitedAt is when the guard was at the place of a record: the time of the scan for scanned
+// records, the capture time for records uploaded by the offline sync and the creation time otherwise
+const securityRecordVisitedAt = "COALESCE(security_records.scanned_at, security_records.captured_at, security_records.created_at)"
+
+// securityRecordCountsAsVisit leaves out the records synced late until an admin reviewed them,
+// their capture time cannot be checked like the time of an online scan (late_sync is NULL on older rows)
+const securityRecordCountsAsVisit = "(security_records.late_sync IS NOT TRUE OR security_records.sync_reviewed_at IS NOT NULL)"
+
+// patrolVisits loads the security records between from and to, optionally of one guard.
+// Scanned and synced records count at the time they were captured, late synced ones once reviewed.
+func patrolVisits(from, to time.Time, securityID uint) ([]patrolVisit, error) {
+	var visits []patrolVisit
+	db := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("id, security_id, checkpoint_id, block, outside_geofence, "+securityRecordVisitedAt+" as visited_at").
+		Where(securityRecordVisitedAt+" >= ? AND "+securityRecordVisitedAt+" < ?", from, to).
+		Where(securityRecordCountsAsVisit).
+		Order("visited_at ASC, id ASC")
+	if securityID != 0 {
+		db = db.Where("security_id = ?", securityID)
+	}
+	err := db.Scan(&visits).Error
+	return visits, err
+}
+
+// patrolNightVisits loads the security records of all rounds of the routes in one query,
+// rounds end at most a day and an interval after the night starts
+func patrolNightVisits(routes []models.PatrolRoute, night time.Time, securityID uint) ([]patrolVisit, error) {
+	var from, to time.Time
+	for _, route := range routes {
+		starts := patrolRoundStarts(route, night)
+		if len(starts) == 0 {
+			continue
+		}
+		end := starts[len(starts)-1].Add(time.Duration(route.Interval_Minutes) * time.Minute)
+		if from.IsZero() || starts[0].Before(from) {
+			from = starts[0]
+		}
+		if end.After(to) {
+			to = end
+		}
+	}
+	if from.IsZero() {
+		return nil, nil
+	}
+	return patrolVisits(from, to, securityID)
+}
+
+// GetPatrolCompliance compares the security records of a night with the patrol routes.
+//
+//	date         night to check (YYYY-MM-DD, the day the night starts), defaults to the current night
+//	route_id     one route instead of all active routes
+//	security_id  only count the records of this guard (admins), security users always get their own
+func GetPatrolCompliance(c *gin.Context) {
+	admin := isAdmin(c)
+	if !admin && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var securityID uint
+	if admin {
+		if idStr := c.Query("security_id"); idStr != "" {
+			id, err := strconv.ParseUint(idStr, 10, 64)
+			if err != nil {
+				c.JSON(400, gin.H{"message": "Invalid security_id"})
+				return
+			}
+			securityID = uint(id)
+		}
+	} else {
+		userID, _ := c.Get("user_id")
+		securityID, _ = userID.(uint)
+	}
+
+	now := time.Now()
+	night := patrolNight(now)
+	if date := c.Query("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, jakartaLocation)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid date format. Use YYYY-MM-DD."})
+			return
+		}
+		night = parsed
+	}
+
+	var routes []models.PatrolRoute
+	db := preloadPatrolStops(initializers.DB).Order("name ASC")
+	if routeID := c.Query("route_id"); routeID != "" {
+		db = db.Where("id = ?", routeID)
+	} else {
+		db = db.Where("is_active = ?", true)
+	}
+	if err := db.Find(&routes).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get patrol routes"})
+		return
+	}
+
+	visits, err := patrolNightVisits(routes, night, securityID)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get security records"})
+		return
+	}
+
+	type RouteCompliance struct {
+		RouteId   uint                `json:"route_id"`
+		RouteName string              `json:"route_name"`
+		Summary   patrolSummary       `json:"summary"`
+		Rounds    []patrolRoundResult `json:"rounds"`
+	}
+	response := make([]RouteCompliance, len(routes))
+	for i, route := range routes {
+		rounds, summary := evaluatePatrol(route, night, visits, now)
+		response[i] = RouteCompliance{RouteId: route.ID, RouteName: route.Name, Summary: summary, Rounds: rounds}
+	}
+
+	result := gin.H{
+		"date": night.Format("2006-01-02"),
+		"data": response,
+	}
+	if securityID != 0 {
+		result["security_id"] = securityID
+	}
+	c.JSON(200, result)
+}
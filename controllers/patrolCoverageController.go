@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxPatrolCoverageDays limits the date range of the coverage statistics
+	maxPatrolCoverageDays = 366
+	// defaultHeatmapCell is the default size of a heatmap cell in meters
+	defaultHeatmapCell = 50
+)
+
+// coverageRecord is a security record as far as the coverage statistics are concerned
+type coverageRecord struct {
+	Security_Id      uint
+	Block            string
+	Purpose          string
+	Outside_Geofence bool
+	Latitude         *float64
+	Longitude        *float64
+	Visited_At       time.Time
+}
+
+type blockCoverage struct {
+	Block              string     `json:"block"`
+	Visits             int        `json:"visits"`
+	Guards             int        `json:"guards"`
+	FirstVisit         *time.Time `json:"first_visit"`
+	LastVisit          *time.Time `json:"last_visit"`
+	AvgIntervalMinutes *float64   `json:"avg_interval_minutes"` // nil with less than two visits
+	MaxIntervalMinutes *float64   `json:"max_interval_minutes"`
+	Hours              [24]int    `json:"hours"` // visits per hour of day (Jakarta time)
+	guards             map[uint]bool
+	previous           time.Time
+	intervalSum        float64
+	intervals          int
+}
+
+// excludedCoverage counts the records that are not a patrol visit of their block
+type excludedCoverage struct {
+	OutsideGeofence int `json:"outside_geofence"` // too far from the nearest checkpoint
+	ResidentVisits  int `json:"resident_visits"`  // visits to the house of a resident
+}
+
+type hourCoverage struct {
+	Hour   int `json:"hour"`
+	Visits int `json:"visits"`
+}
+
+type guardCoverage struct {
+	SecurityId   uint    `json:"security_id"`
+	SecurityName string  `json:"security_name"`
+	Visits       int     `json:"visits"`
+	Blocks       int     `json:"blocks"`
+	Hours        [24]int `json:"hours"`
+	blocks       map[string]bool
+}
+
+type heatmapCell struct {
+	Latitude  float64 `json:"latitude"` // center of the cell
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	Intensity float64 `json:"intensity"` // count relative to the busiest cell, 0-1
+}
+
+// knownBlocks lists the blocks of the residents and checkpoints, so blocks nobody visited show up too
+func knownBlocks() ([]string, error) {
+	var blocks []string
+	err := initializers.DB.Raw("SELECT block FROM users WHERE role_id = ? AND block <> '' AND deleted_at IS NULL "+
+		"UNION SELECT block FROM checkpoints WHERE is_active = ? AND block <> '' AND deleted_at IS NULL", 2, true).
+		Scan(&blocks).Error
+	return blocks, err
+}
+
+// coverageHeatmap counts the records with coordinates in square cells of about cellSize meters.
+// The grid is aligned on whole multiples of the cell size in degrees, with the longitude step
+// scaled by the mean latitude of the records so cells stay roughly square.
+func coverageHeatmap(records []coverageRecord, cellSize float64) []heatmapCell {
+	var latSum float64
+	var located int
+	for _, r := range records {
+		if r.Latitude != nil && r.Longitude != nil {
+			latSum += *r.Latitude
+			located++
+		}
+	}
+	cells := []heatmapCell{}
+	if located == 0 {
+		return cells
+	}
+
+	latStep := cellSize / earthRadius * 180 / math.Pi
+	lngStep := latStep / math.Max(math.Cos(latSum/float64(located)*math.Pi/180), 0.01)
+
+	type cellKey struct{ lat, lng int64 }
+	counts := map[cellKey]int{}
+	for _, r := range records {
+		if r.Latitude == nil || r.Longitude == nil {
+			continue
+		}
+		counts[cellKey{int64(math.Floor(*r.Latitude / latStep)), int64(math.Floor(*r.Longitude / lngStep))}]++
+	}
+
+	max := 0
+	for _, count := range counts {
+		if count > max {
+			max = count
+		}
+	}
+	for key, count := range counts {
+		cells = append(cells, heatmapCell{
+			Latitude:  (float64(key.lat) + 0.5) * latStep,
+			Longitude: (float64(key.lng) + 0.5) * lngStep,
+			Count:     count,
+			Intensity: math.Round(float64(count)/float64(max)*1000) / 1000,
+		})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		if cells[i].Latitude != cells[j].Latitude {
+			return cells[i].Latitude < cells[j].Latitude
+		}
+		return cells[i].Longitude < cells[j].Longitude
+	})
+	return cells
+}
+
+// GetPatrolCoverage aggregates the security records of a date range to show which blocks get neglected.
+//
+//	from, to  date range (YYYY-MM-DD, inclusive), defaults to the last 30 days
+//	cell      heatmap cell size in meters (10-1000, default 50)
+//
+// Visits are counted at the scan time for scanned records, records synced late only once reviewed.
+// Records outside the geofence and visits to a resident are not patrol visits, they are only counted
+// in "excluded" and the heatmap, which shows where the guards were.
+// The average interval is the mean time between two consecutive visits of a block by any guard within the range.
+func GetPatrolCoverage(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	today := time.Now().In(jakartaLocation)
+	to := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, jakartaLocation).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -30)
+	if fromStr, toStr := c.Query("from"), c.Query("to"); fromStr != "" || toStr != "" {
+		if fromStr == "" || toStr == "" {
+			c.JSON(400, gin.H{"message": "Send both from and to"})
+			return
+		}
+		var err error
+		if from, err = time.ParseInLocation("2006-01-02", fromStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid from date. Use YYYY-MM-DD."})
+			return
+		}
+		if to, err = time.ParseInLocation("2006-01-02", toStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid to date. Use YYYY-MM-DD."})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+		if !from.Before(to) {
+			c.JSON(400, gin.H{"message": "from must not be after to"})
+			return
+		}
+		if to.Sub(from) > maxPatrolCoverageDays*24*time.Hour {
+			c.JSON(400, gin.H{"message": fmt.Sprintf("The date range can be at most %d days", maxPatrolCoverageDays)})
+			return
+		}
+	}
+
+	cellSize := float64(defaultHeatmapCell)
+	if cellStr := c.Query("cell"); cellStr != "" {
+		size, err := strconv.ParseFloat(cellStr, 64)
+		if err != nil || size < 10 || size > 1000 {
+			c.JSON(400, gin.H{"message": "cell must be between 10 and 1000 meters"})
+			return
+		}
+		cellSize = size
+	}
+
+	var records []coverageRecord
+	if err := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("security_id, block, purpose, outside_geofence, latitude, longitude, "+securityRecordVisitedAt+" as visited_at").
+		Where(securityRecordVisitedAt+" >= ? AND "+securityRecordVisitedAt+" < ?", from, to).
+		Where(securityRecordCountsAsVisit).
+		Order("visited_at ASC, id ASC").
+		Scan(&records).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get security records"})
+		return
+	}
+	blockNames, err := knownBlocks()
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get blocks"})
+		return
+	}
+
+	blocks := map[string]*blockCoverage{}
+	guards := map[uint]*guardCoverage{}
+	var hours [24]int
+	var excluded excludedCoverage
+	visits := 0
+	for _, r := range records {
+		switch {
+		case r.Purpose == securityRecordResidentVisit:
+			excluded.ResidentVisits++
+			continue
+		case r.Outside_Geofence:
+			excluded.OutsideGeofence++
+			continue
+		}
+		visits++
+		hour := r.Visited_At.In(jakartaLocation).Hour()
+		hours[hour]++
+
+		guard := guards[r.Security_Id]
+		if guard == nil {
+			guard = &guardCoverage{SecurityId: r.Security_Id, blocks: map[string]bool{}}
+			guards[r.Security_Id] = guard
+		}
+		guard.Visits++
+		guard.Hours[hour]++
+
+		// Records without a block still count for the guard and the heatmap
+		if r.Block == "" {
+			continue
+		}
+		guard.blocks[r.Block] = true
+
+		block := blocks[r.Block]
+		if block == nil {
+			block = &blockCoverage{Block: r.Block, guards: map[uint]bool{}}
+			blocks[r.Block] = block
+		}
+		if !block.previous.IsZero() {
+			interval := r.Visited_At.Sub(block.previous).Minutes()
+			block.intervalSum += interval
+			block.intervals++
+			if block.MaxIntervalMinutes == nil || interval > *block.MaxIntervalMinutes {
+				block.MaxIntervalMinutes = &interval
+			}
+		} else {
+			first := r.Visited_At
+			block.FirstVisit = &first
+		}
+		last := r.Visited_At
+		block.LastVisit = &last
+		block.previous = r.Visited_At
+		block.Visits++
+		block.Hours[hour]++
+		block.guards[r.Security_Id] = true
+	}
+
+	byBlock := make([]blockCoverage, 0, len(blocks))
+	for _, block := range blocks {
+		block.Guards = len(block.guards)
+		if block.intervals > 0 {
+			avg := math.Round(block.intervalSum/float64(block.intervals)*10) / 10
+			block.AvgIntervalMinutes = &avg
+			max := math.Round(*block.MaxIntervalMinutes*10) / 10
+			block.MaxIntervalMinutes = &max
+		}
+		byBlock = append(byBlock, *block)
+	}
+	// The least visited blocks first
+	sort.Slice(byBlock, func(i, j int) bool {
+		if byBlock[i].Visits != byBlock[j].Visits {
+			return byBlock[i].Visits < byBlock[j].Visits
+		}
+		return byBlock[i].Block < byBlock[j].Block
+	})
+
+	unvisited := []string{}
+	for _, name := range blockNames {
+		if blocks[name] == nil {
+			unvisited = append(unvisited, name)
+		}
+	}
+	sort.Strings(unvisited)
+
+	byHour := make([]hourCoverage, 24)
+	for hour := range hours {
+		byHour[hour] = hourCoverage{Hour: hour, Visits: hours[hour]}
+	}
+
+	byGuard := make([]guardCoverage, 0, len(guards))
+	if len(guards) > 0 {
+		ids := make([]uint, 0, len(guards))
+		for id := range guards {
+			ids = append(ids, id)
+		}
+		var users []models.User
+		// Unscoped so deleted guards keep their name
+		initializers.DB.Unscoped().Select("id, name").Where("id IN ?", ids).Find(&users)
+		for _, user := range users {
+			guards[user.ID].SecurityName = user.Name
+		}
+	}
+	for _, guard := range guards {
+		guard.Blocks = len(guard.blocks)
+		byGuard = append(byGuard, *guard)
+	}
+	sort.Slice(byGuard, func(i, j int) bool {
+		if byGuard[i].Visits != byGuard[j].Visits {
+			return byGuard[i].Visits > byGuard[j].Visits
+		}
+		return byGuard[i].SecurityId < byGuard[j].SecurityId
+	})
+
+	c.JSON(200, gin.H{
+		"data": gin.H{
+			"from":             from.Format("2006-01-02"),
+			"to":               to.AddDate(0, 0, -1).Format("2006-01-02"),
+			"total_visits":     visits,
+			"excluded":         excluded,
+			"by_block":         byBlock,
+			"unvisited_blocks": unvisited,
+			"by_hour":          byHour,
+			"by_guard":         byGuard,
+			"heatmap": gin.H{
+				"cell_size": cellSize,
+				"cells":     coverageHeatmap(records, cellSize),
+			},
+		},
+	})
+}
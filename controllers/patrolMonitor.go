@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/dontkeep/simaling-backend/notify"
+	"gorm.io/gorm/clause"
+)
+
+// Types of patrol alerts
+const (
+	patrolAlertInactivity = "inactivity"
+	patrolAlertMissed     = "missed_patrol"
+)
+
+// missedPatrolAlertWindow limits alerts to rounds that ended recently,
+// so a restart in the morning does not raise an alert for every round of the night
+const missedPatrolAlertWindow = time.Hour
+
+// MonitorPatrols checks the running shifts and patrol routes every interval until ctx is cancelled.
+// It is meant to be started in its own goroutine.
+func MonitorPatrols(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if err := checkInactivity(ctx, now); err != nil {
+			log.Printf("Patrol monitor: failed to check inactivity: %v", err)
+		}
+		if err := checkMissedPatrols(ctx, now); err != nil {
+			log.Printf("Patrol monitor: failed to check patrol rounds: %v", err)
+		}
+		if err := escalatePatrolAlerts(ctx, now); err != nil {
+			log.Printf("Patrol monitor: failed to escalate alerts: %v", err)
+		}
+	}
+}
+
+// onDutyAssignment is an assignment to a running shift the guard has not clocked out of
+type onDutyAssignment struct {
+	ID            uint
+	Shift_Id      uint
+	Shift_Name    string
+	Start_At      time.Time
+	Security_Id   uint
+	Security_Name string
+	Phone_No      string
+	Clock_In_At   *time.Time
+}
+
+func onDutyAssignments(now time.Time) ([]onDutyAssignment, error) {
+	var assignments []onDutyAssignment
+	err := initializers.DB.Model(&models.ShiftAssignment{}).
+		Select("shift_assignments.id, shift_assignments.shift_id, shifts.name as shift_name, shifts.start_at, "+
+			"shift_assignments.security_id, users.name as security_name, users.phone_no, shift_assignments.clock_in_at").
+		Joins("join shifts on shifts.id = shift_assignments.shift_id AND shifts.deleted_at IS NULL").
+		Joins("left join users on users.id = shift_assignments.security_id").
+		Where("shifts.start_at <= ? AND shifts.end_at > ? AND shift_assignments.clock_out_at IS NULL", now, now).
+		Scan(&assignments).Error
+	return assignments, err
+}
+
+// patrolAlertRecipients are the guards on duty and the admins
+func patrolAlertRecipients(onDuty []onDutyAssignment) []notify.Recipient {
+	var recipients []notify.Recipient
+	seen := map[uint]bool{}
+	for _, a := range onDuty {
+		if !seen[a.Security_Id] {
+			seen[a.Security_Id] = true
+			recipients = append(recipients, notify.Recipient{UserID: a.Security_Id, Name: a.Security_Name, Phone: a.Phone_No})
+		}
+	}
+	return append(recipients, adminRecipients()...)
+}
+
+func adminRecipients() []notify.Recipient {
+	var admins []models.User
+	initializers.DB.Select("id, name, phone_no").Where("role_id = ?", 1).Find(&admins)
+	recipients := make([]notify.Recipient, len(admins))
+	for i, admin := range admins {
+		recipients[i] = notify.Recipient{UserID: admin.ID, Name: admin.Name, Phone: admin.Phone_No}
+	}
+	return recipients
+}
+
+// raisePatrolAlert stores the alert and notifies the recipients, unless an alert with the same Dedup_Key exists
+func raisePatrolAlert(ctx context.Context, alert models.PatrolAlert, recipients []notify.Recipient) error {
+	alert.Status = "Open"
+	result := initializers.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	var response patrolAlertResponse
+	patrolAlertQuery().Where("patrol_alerts.id = ?", alert.ID).Scan(&response)
+	publishEvent("patrol_alert.raised", response, staffAudience())
+	initializers.Notifier.Send(ctx, notify.Message{
+		Event:      "patrol_alert",
+		Title:      "Patrol alert",
+		Body:       alert.Message,
+		Recipients: recipients,
+		Data:       map[string]interface{}{"alert_id": alert.ID, "type": alert.Type},
+	})
+	return nil
+}
+
+// checkInactivity raises an alert for every guard on duty whose last security record (or clock in,
+// or the start of the shift) is older than PATROL_INACTIVITY_TIMEOUT
+func checkInactivity(ctx context.Context, now time.Time) error {
+	onDuty, err := onDutyAssignments(now)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range onDuty {
+		last := a.Start_At
+		if a.Clock_In_At != nil && a.Clock_In_At.After(last) {
+			last = *a.Clock_In_At
+		}
+		visits, err := patrolVisits(last, now, a.Security_Id)
+		if err != nil {
+			return err
+		}
+		if len(visits) > 0 {
+			last = visits[len(visits)-1].Visited_At
+		}
+		if now.Sub(last) < initializers.AppConfig.PatrolInactivityTimeout {
+			continue
+		}
+
+		securityID, shiftID := a.Security_Id, a.Shift_Id
+		alert := models.PatrolAlert{
+			Type: patrolAlertInactivity,
+			// A new record resets the timer, so the next silence raises a new alert
+			Dedup_Key:   fmt.Sprintf("%s:%d:%d", patrolAlertInactivity, a.ID, last.Unix()),
+			Security_Id: &securityID,
+			Shift_Id:    &shiftID,
+			Message: fmt.Sprintf("%s (%s) has not sent a security record since %s",
+				a.Security_Name, a.Shift_Name, last.In(jakartaLocation).Format("15:04")),
+		}
+		if err := raisePatrolAlert(ctx, alert, patrolAlertRecipients(onDuty)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMissedPatrols raises an alert for every round of an active patrol route that ended with missed stops
+func checkMissedPatrols(ctx context.Context, now time.Time) error {
+	var routes []models.PatrolRoute
+	if err := preloadPatrolStops(initializers.DB).Where("is_active = ?", true).Find(&routes).Error; err != nil {
+		return err
+	}
+	night := patrolNight(now)
+	visits, err := patrolNightVisits(routes, night, 0)
+	if err != nil {
+		return err
+	}
+
+	var onDuty []onDutyAssignment
+	for _, route := range routes {
+		rounds, _ := evaluatePatrol(route, night, visits, now)
+		for _, round := range rounds {
+			if round.Status != patrolMissed || round.End.Before(now.Add(-missedPatrolAlertWindow)) {
+				continue
+			}
+
+			var missed []string
+			for _, stop := range round.Stops {
+				if stop.Status != patrolMissed {
+					continue
+				}
+				if stop.CheckpointName != "" {
+					missed = append(missed, stop.CheckpointName)
+				} else {
+					missed = append(missed, "Block "+stop.Block)
+				}
+			}
+
+			if onDuty == nil {
+				if onDuty, err = onDutyAssignments(now); err != nil {
+					return err
+				}
+			}
+			routeID := route.ID
+			alert := models.PatrolAlert{
+				Type:      patrolAlertMissed,
+				Dedup_Key: fmt.Sprintf("%s:%d:%s:%d", patrolAlertMissed, route.ID, night.Format("2006-01-02"), round.Round),
+				Route_Id:  &routeID,
+				Round:     round.Round,
+				Message: fmt.Sprintf("Round %d of %s (%s-%s) was missed: %s", round.Round, route.Name,
+					round.Start.Format("15:04"), round.End.Format("15:04"), strings.Join(missed, ", ")),
+			}
+			if err := raisePatrolAlert(ctx, alert, patrolAlertRecipients(onDuty)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// escalatePatrolAlerts sends the alerts nobody acknowledged within PATROL_ALERT_ESCALATION to the RT head.
+// An alert is only marked escalated once every channel delivered it, otherwise it is retried on the next run.
+func escalatePatrolAlerts(ctx context.Context, now time.Time) error {
+	var alerts []models.PatrolAlert
+	err := initializers.DB.
+		Where("status = ? AND escalated_at IS NULL AND created_at <= ?", "Open", now.Add(-initializers.AppConfig.PatrolAlertEscalation)).
+		Find(&alerts).Error
+	if err != nil || len(alerts) == 0 {
+		return err
+	}
+
+	recipients := adminRecipients()
+	if initializers.AppConfig.RTHeadPhone != "" {
+		name := initializers.AppConfig.RTHeadName
+		if name == "" {
+			name = "Ketua RT"
+		}
+		recipients = []notify.Recipient{{Name: name, Phone: initializers.AppConfig.RTHeadPhone}}
+	}
+
+	for _, alert := range alerts {
+		err := initializers.Notifier.Send(ctx, notify.Message{
+			Event:      "patrol_alert_escalated",
+			Title:      "Unacknowledged patrol alert",
+			Body:       fmt.Sprintf("%s (raised at %s)", alert.Message, alert.CreatedAt.In(jakartaLocation).Format("15:04")),
+			Recipients: recipients,
+			Data:       map[string]interface{}{"alert_id": alert.ID, "type": alert.Type},
+		})
+		if err != nil {
+			continue
+		}
+		if err := initializers.DB.Model(&alert).Update("escalated_at", now).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// minPatrolInterval keeps the number of rounds per night reasonable
+const minPatrolInterval = 15
+
+type patrolStopResponse struct {
+	ID             uint   `json:"id"`
+	Position       int    `json:"position"`
+	CheckpointId   *uint  `json:"checkpoint_id"`
+	CheckpointName string `json:"checkpoint_name"`
+	Block          string `json:"block"`
+}
+
+type patrolRouteResponse struct {
+	ID              uint                 `json:"id"`
+	Name            string               `json:"name"`
+	StartTime       string               `json:"start_time"`
+	EndTime         string               `json:"end_time"`
+	IntervalMinutes int                  `json:"interval_minutes"`
+	GraceMinutes    int                  `json:"grace_minutes"`
+	IsActive        bool                 `json:"is_active"`
+	Stops           []patrolStopResponse `json:"stops"`
+}
+
+func toPatrolRouteResponse(route models.PatrolRoute) patrolRouteResponse {
+	stops := make([]patrolStopResponse, len(route.Stops))
+	for i, stop := range route.Stops {
+		stops[i] = patrolStopResponse{
+			ID:             stop.ID,
+			Position:       stop.Position,
+			CheckpointId:   stop.Checkpoint_Id,
+			CheckpointName: stop.Checkpoint.Name,
+			Block:          stop.Block,
+		}
+	}
+	return patrolRouteResponse{
+		ID:              route.ID,
+		Name:            route.Name,
+		StartTime:       route.Start_Time,
+		EndTime:         route.End_Time,
+		IntervalMinutes: route.Interval_Minutes,
+		GraceMinutes:    route.Grace_Minutes,
+		IsActive:        route.Is_Active,
+		Stops:           stops,
+	}
+}
+
+// preloadPatrolStops loads the stops of a route in order, with their checkpoint
+func preloadPatrolStops(db *gorm.DB) *gorm.DB {
+	return db.Preload("Stops", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC")
+	}).Preload("Stops.Checkpoint", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped()
+	})
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time %q, use HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// patrolRouteBody is the request body of CreatePatrolRoute and UpdatePatrolRoute
+type patrolRouteBody struct {
+	Name            *string `json:"name"`
+	StartTime       *string `json:"start_time"`
+	EndTime         *string `json:"end_time"`
+	IntervalMinutes *int    `json:"interval_minutes"`
+	GraceMinutes    *int    `json:"grace_minutes"`
+	IsActive        *bool   `json:"is_active"`
+	Stops           *[]struct {
+		CheckpointId *uint  `json:"checkpoint_id"`
+		Block        string `json:"block"`
+	} `json:"stops"`
+}
+
+// apply copies the fields present in the body to the route and validates the result.
+// The stops are returned separately because they replace the existing ones.
+func (body patrolRouteBody) apply(route *models.PatrolRoute) ([]models.PatrolRouteStop, error) {
+	if body.Name != nil {
+		route.Name = *body.Name
+	}
+	if body.StartTime != nil {
+		route.Start_Time = *body.StartTime
+	}
+	if body.EndTime != nil {
+		route.End_Time = *body.EndTime
+	}
+	if body.IntervalMinutes != nil {
+		route.Interval_Minutes = *body.IntervalMinutes
+	}
+	if body.GraceMinutes != nil {
+		route.Grace_Minutes = *body.GraceMinutes
+	}
+	if body.IsActive != nil {
+		route.Is_Active = *body.IsActive
+	}
+
+	if route.Name == "" {
+		return nil, fmt.Errorf("Name is required")
+	}
+	start, err := parseClock(route.Start_Time)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(route.End_Time)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("Start and end time must be different")
+	}
+	if route.Interval_Minutes < minPatrolInterval {
+		return nil, fmt.Errorf("interval_minutes must be at least %d", minPatrolInterval)
+	}
+	if route.Grace_Minutes < 0 || route.Grace_Minutes > route.Interval_Minutes {
+		return nil, fmt.Errorf("grace_minutes must be between 0 and interval_minutes")
+	}
+
+	if body.Stops == nil {
+		return nil, nil
+	}
+	if len(*body.Stops) == 0 {
+		return nil, fmt.Errorf("A route needs at least one stop")
+	}
+	stops := make([]models.PatrolRouteStop, len(*body.Stops))
+	for i, s := range *body.Stops {
+		if s.CheckpointId == nil && s.Block == "" {
+			return nil, fmt.Errorf("Stop %d needs a checkpoint_id or a block", i+1)
+		}
+		if s.CheckpointId != nil {
+			var count int64
+			initializers.DB.Model(&models.Checkpoint{}).Where("id = ?", *s.CheckpointId).Count(&count)
+			if count == 0 {
+				return nil, fmt.Errorf("Checkpoint %d not found", *s.CheckpointId)
+			}
+		}
+		stops[i] = models.PatrolRouteStop{Position: i, Checkpoint_Id: s.CheckpointId, Block: s.Block}
+		if s.CheckpointId != nil {
+			// A checkpoint stop is matched by checkpoint only
+			stops[i].Block = ""
+		}
+	}
+	return stops, nil
+}
+
+// GetPatrolRoutes lists the patrol routes with their stops
+func GetPatrolRoutes(c *gin.Context) {
+	if !isAdmin(c) && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var routes []models.PatrolRoute
+	db := preloadPatrolStops(initializers.DB).Order("name ASC")
+	if c.Query("active") == "true" {
+		db = db.Where("is_active = ?", true)
+	}
+	if err := db.Find(&routes).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get patrol routes"})
+		return
+	}
+
+	response := make([]patrolRouteResponse, len(routes))
+	for i, route := range routes {
+		response[i] = toPatrolRouteResponse(route)
+	}
+	c.JSON(200, gin.H{"data": response})
+}
+
+// GetPatrolRouteById gets a patrol route by id
+func GetPatrolRouteById(c *gin.Context) {
+	if !isAdmin(c) && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var route models.PatrolRoute
+	if err := preloadPatrolStops(initializers.DB).Where("id = ?", c.Param("id")).First(&route).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Patrol route not found"})
+		return
+	}
+	c.JSON(200, gin.H{"data": toPatrolRouteResponse(route)})
+}
+
+// CreatePatrolRoute adds a route, for example
+//
+//	{"name": "Malam", "start_time": "22:00", "end_time": "04:00", "interval_minutes": 120, "grace_minutes": 30,
+//	 "stops": [{"checkpoint_id": 1}, {"block": "B"}]}
+func CreatePatrolRoute(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var body patrolRouteBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.Stops == nil {
+		c.JSON(400, gin.H{"message": "A route needs at least one stop"})
+		return
+	}
+
+	route := models.PatrolRoute{Is_Active: true}
+	stops, err := body.apply(&route)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+	route.Stops = stops
+
+	if err := initializers.DB.Create(&route).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create patrol route"})
+		return
+	}
+
+	route.Stops = nil
+	preloadPatrolStops(initializers.DB).First(&route, route.ID)
+	c.JSON(200, gin.H{
+		"message": "Patrol route created successfully",
+		"data":    toPatrolRouteResponse(route),
+	})
+}
+
+// UpdatePatrolRoute changes the fields present in the request body, stops replace the existing ones
+func UpdatePatrolRoute(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var route models.PatrolRoute
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&route).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Patrol route not found"})
+		return
+	}
+
+	var body patrolRouteBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	stops, err := body.apply(&route)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&route).Error; err != nil {
+			return err
+		}
+		if stops == nil {
+			return nil
+		}
+		if err := tx.Where("route_id = ?", route.ID).Delete(&models.PatrolRouteStop{}).Error; err != nil {
+			return err
+		}
+		for i := range stops {
+			stops[i].Route_Id = route.ID
+		}
+		return tx.Create(&stops).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update patrol route"})
+		return
+	}
+
+	preloadPatrolStops(initializers.DB).First(&route, route.ID)
+	c.JSON(200, gin.H{
+		"message": "Patrol route updated",
+		"data":    toPatrolRouteResponse(route),
+	})
+}
+
+// DeletePatrolRoute removes a patrol route
+func DeletePatrolRoute(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var route models.PatrolRoute
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&route).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Patrol route not found"})
+		return
+	}
+	if err := initializers.DB.Delete(&route).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete patrol route"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Patrol route deleted"})
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	// checkpointScanMaxAge is how long after the scan a record can still be submitted
+	checkpointScanMaxAge = 10 * time.Minute
+	// checkpointScanClockSkew tolerates phones whose clock is slightly ahead
+	checkpointScanClockSkew = 2 * time.Minute
+	// checkpointRescanInterval is the minimum time between two scans of the same checkpoint by the same guard
+	checkpointRescanInterval = time.Minute
+)
+
+// isDuplicateKey checks if err is MySQL refusing a row that already exists in the given unique index
+func isDuplicateKey(err error, index string) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, index)
+}
+
+// ScanCheckpoint creates a security record from the QR code (or NFC tag) of a checkpoint.
+// The token must be signed by the server and match the current code of the checkpoint,
+// scan_id is a unique id generated by the app for every scan so a scan cannot be submitted twice.
+// The coordinates are required, a photo of the printed code scanned elsewhere is flagged outside the geofence.
+func ScanCheckpoint(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+
+	var body struct {
+		Token     string     `json:"token"`
+		ScanId    string     `json:"scan_id"`
+		ScannedAt *time.Time `json:"scanned_at"`
+		Longitude *float64   `json:"longitude"`
+		Latitude  *float64   `json:"latitude"`
+		Accuracy  float64    `json:"accuracy"`  // GPS accuracy in meters as reported by the phone
+		PhotoIds  []uint     `json:"photo_ids"` // photos uploaded ahead with POST /security-records/photos
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.ScanId == "" || len(body.ScanId) > 64 {
+		c.JSON(400, gin.H{"message": "scan_id is required (max 64 characters)"})
+		return
+	}
+	if body.Latitude == nil || body.Longitude == nil {
+		c.JSON(400, gin.H{"message": "latitude and longitude are required"})
+		return
+	}
+	if !validCoordinates(*body.Latitude, *body.Longitude) {
+		c.JSON(400, gin.H{"message": "Invalid coordinates"})
+		return
+	}
+	body.PhotoIds = uniquePhotoIDs(body.PhotoIds)
+	if len(body.PhotoIds) > maxSecurityRecordPhotos {
+		c.JSON(400, gin.H{"message": fmt.Sprintf("A security record can have at most %d photos", maxSecurityRecordPhotos)})
+		return
+	}
+
+	// Get security user id from context
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+	securityID, ok := userID.(uint)
+	if !ok {
+		c.JSON(400, gin.H{"message": "Invalid user ID"})
+		return
+	}
+
+	// A forged or damaged code fails the signature check
+	checkpointID, nonce, ok := parseCheckpointToken(body.Token)
+	if !ok {
+		c.JSON(400, gin.H{"message": "Invalid checkpoint code"})
+		return
+	}
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ? AND is_active = ?", checkpointID, true).First(&checkpoint).Error; err != nil {
+		c.JSON(400, gin.H{"message": "Invalid checkpoint code"})
+		return
+	}
+	if checkpoint.Token_Nonce != nonce {
+		c.JSON(400, gin.H{"message": "This checkpoint code has been replaced, scan the new code"})
+		return
+	}
+
+	now := time.Now()
+	scannedAt := now
+	if body.ScannedAt != nil {
+		scannedAt = *body.ScannedAt
+	}
+	if scannedAt.After(now.Add(checkpointScanClockSkew)) {
+		c.JSON(400, gin.H{"message": "Scan time is in the future"})
+		return
+	}
+	if scannedAt.Before(now.Add(-checkpointScanMaxAge)) {
+		c.JSON(400, gin.H{"message": "Scan is too old, scan the checkpoint again"})
+		return
+	}
+
+	// Replays: the same scan sent again, or the same code scanned over and over
+	var count int64
+	initializers.DB.Model(&models.SecurityRecord{}).Where("scan_nonce = ?", body.ScanId).Count(&count)
+	if count > 0 {
+		c.JSON(409, gin.H{"message": "This scan was already submitted"})
+		return
+	}
+	initializers.DB.Model(&models.SecurityRecord{}).
+		Where("security_id = ? AND checkpoint_id = ? AND method = ? AND scanned_at > ? AND scanned_at < ?",
+			securityID, checkpoint.ID, "qr", scannedAt.Add(-checkpointRescanInterval), scannedAt.Add(checkpointRescanInterval)).
+		Count(&count)
+	if count > 0 {
+		c.JSON(409, gin.H{"message": "This checkpoint was just scanned"})
+		return
+	}
+
+	// Get security user (for name and phone_no)
+	var securityUser models.User
+	if err := initializers.DB.Select("name, phone_no").Where("id = ?", securityID).First(&securityUser).Error; err != nil {
+		c.JSON(400, gin.H{"message": "Security user not found"})
+		return
+	}
+
+	scanNonce := body.ScanId
+	securityRecord := models.SecurityRecord{
+		Security_Id:   securityID,
+		Block:         checkpoint.Block,
+		Phone_No:      securityUser.Phone_No,
+		Checkpoint_Id: &checkpoint.ID,
+		Latitude:      body.Latitude,
+		Longitude:     body.Longitude,
+		Accuracy:      body.Accuracy,
+		Low_Accuracy:  body.Accuracy > initializers.AppConfig.GPSMaxAccuracy,
+		Outside_Shift: !onShift(securityID, scannedAt),
+		Method:        "qr",
+		Scanned_At:    &scannedAt,
+		Scan_Nonce:    &scanNonce,
+	}
+	distance := distanceMeters(*body.Latitude, *body.Longitude, checkpoint.Latitude, checkpoint.Longitude)
+	securityRecord.Distance = math.Round(distance*10) / 10
+	securityRecord.Outside_Geofence = distance > checkpoint.Radius
+
+	// The unique index on scan_nonce rejects a concurrent duplicate
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&securityRecord).Error; err != nil {
+			return err
+		}
+		return attachSecurityRecordPhotos(tx, securityRecord.ID, securityID, nil, body.PhotoIds)
+	})
+	if errors.Is(err, errInvalidRecordPhotos) {
+		c.JSON(400, gin.H{"message": "Unknown, used or expired photo_ids, upload the photos again"})
+		return
+	}
+	if isDuplicateKey(err, "idx_security_records_scan_nonce") {
+		c.JSON(409, gin.H{"message": "This scan was already submitted"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to save security record"})
+		return
+	}
+	photos, _ := securityRecordPhotos([]uint{securityRecord.ID})
+
+	response := struct {
+		ID              uint                          `json:"id"`
+		SecurityId      uint                          `json:"security_id"`
+		SecurityName    string                        `json:"security_name"`
+		Block           string                        `json:"block"`
+		PhoneNo         string                        `json:"phone_no"`
+		Longitude       coordinateText                `json:"longitude"`
+		Latitude        coordinateText                `json:"latitude"`
+		CheckpointId    uint                          `json:"checkpoint_id"`
+		CheckpointName  string                        `json:"checkpoint_name"`
+		Distance        float64                       `json:"distance"`
+		Accuracy        float64                       `json:"accuracy"`
+		OutsideGeofence bool                          `json:"outside_geofence"`
+		LowAccuracy     bool                          `json:"low_accuracy"`
+		OutsideShift    bool                          `json:"outside_shift"`
+		Method          string                        `json:"method"`
+		ScannedAt       time.Time                     `json:"scanned_at"`
+		CreatedAt       time.Time                     `json:"created_at"`
+		Photos          []securityRecordPhotoResponse `json:"photos"`
+	}{
+		ID:              securityRecord.ID,
+		SecurityId:      securityID,
+		SecurityName:    securityUser.Name,
+		Block:           securityRecord.Block,
+		PhoneNo:         securityRecord.Phone_No,
+		Longitude:       formatCoordinate(securityRecord.Longitude),
+		Latitude:        formatCoordinate(securityRecord.Latitude),
+		CheckpointId:    checkpoint.ID,
+		CheckpointName:  checkpoint.Name,
+		Distance:        securityRecord.Distance,
+		Accuracy:        securityRecord.Accuracy,
+		OutsideGeofence: securityRecord.Outside_Geofence,
+		LowAccuracy:     securityRecord.Low_Accuracy,
+		OutsideShift:    securityRecord.Outside_Shift,
+		Method:          securityRecord.Method,
+		ScannedAt:       scannedAt,
+		CreatedAt:       securityRecord.CreatedAt,
+		Photos:          photos[securityRecord.ID],
+	}
+	if response.Photos == nil {
+		response.Photos = []securityRecordPhotoResponse{}
+	}
+
+	residentEvent := response
+	residentEvent.Photos = []securityRecordPhotoResponse{}
+	publishSecurityRecord(securityRecord, response, residentEvent)
+
+	c.JSON(200, gin.H{
+		"message": "Checkpoint scanned successfully",
+		"data":    response,
+	})
+}
@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+// maxPatrolTrackDays limits the date range of a track
+const maxPatrolTrackDays = 31
+
+// patrolTrackPoint is a security record with coordinates, in the order the guard walked
+type patrolTrackPoint struct {
+	ID              uint
+	Block           string
+	Latitude        float64
+	Longitude       float64
+	CheckpointId    *uint
+	CheckpointName  string
+	Distance        float64
+	Accuracy        float64
+	OutsideGeofence bool
+	LowAccuracy     bool
+	OutsideShift    bool
+	Method          string
+	VisitedAt       time.Time
+}
+
+// insideGeofence is nil when the record was not matched to a checkpoint
+func (p patrolTrackPoint) insideGeofence() *bool {
+	if p.CheckpointId == nil {
+		return nil
+	}
+	inside := !p.OutsideGeofence
+	return &inside
+}
+
+func (p patrolTrackPoint) label() string {
+	if p.CheckpointName != "" {
+		return p.CheckpointName
+	}
+	return p.Block
+}
+
+// patrolTrackPoints loads the records of a guard between from and to that have coordinates.
+// Scanned records are placed at the time of the scan.
+func patrolTrackPoints(securityID uint, from, to time.Time) ([]patrolTrackPoint, error) {
+	var points []patrolTrackPoint
+	err := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("security_records.id, security_records.block, security_records.latitude, security_records.longitude, "+
+			"security_records.checkpoint_id, checkpoints.name as checkpoint_name, security_records.distance, security_records.accuracy, "+
+			"security_records.outside_geofence, security_records.low_accuracy, security_records.outside_shift, security_records.method, "+
+			securityRecordVisitedAt+" as visited_at").
+		Joins(securityRecordCheckpointJoin).
+		Where("security_records.security_id = ? AND security_records.latitude IS NOT NULL AND security_records.longitude IS NOT NULL", securityID).
+		Where(securityRecordVisitedAt+" >= ? AND "+securityRecordVisitedAt+" < ?", from, to).
+		Order("visited_at ASC, security_records.id ASC").
+		Scan(&points).Error
+	return points, err
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// patrolTrackGeoJSON returns one Point feature per record followed by a LineString of the whole track.
+// GeoJSON positions are [longitude, latitude].
+func patrolTrackGeoJSON(guard models.User, from, to time.Time, points []patrolTrackPoint) geoJSONFeatureCollection {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	line := make([][2]float64, 0, len(points))
+	for i, p := range points {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: [2]float64{p.Longitude, p.Latitude}},
+			Properties: map[string]interface{}{
+				"kind":             "visit",
+				"sequence":         i + 1,
+				"record_id":        p.ID,
+				"block":            p.Block,
+				"checkpoint_id":    p.CheckpointId,
+				"checkpoint_name":  p.CheckpointName,
+				"timestamp":        p.VisitedAt.In(jakartaLocation).Format(time.RFC3339),
+				"method":           p.Method,
+				"distance":         p.Distance,
+				"accuracy":         p.Accuracy,
+				"inside_geofence":  p.insideGeofence(),
+				"outside_geofence": p.OutsideGeofence,
+				"low_accuracy":     p.LowAccuracy,
+				"outside_shift":    p.OutsideShift,
+				"security_id":      guard.ID,
+				"security_name":    guard.Name,
+			},
+		})
+		line = append(line, [2]float64{p.Longitude, p.Latitude})
+	}
+	// A LineString needs at least two positions
+	if len(line) >= 2 {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: line},
+			Properties: map[string]interface{}{
+				"kind":          "track",
+				"security_id":   guard.ID,
+				"security_name": guard.Name,
+				"from":          from.Format(time.RFC3339),
+				"to":            to.Format(time.RFC3339),
+				"points":        len(line),
+			},
+		})
+	}
+	return collection
+}
+
+type gpxDocument struct {
+	XMLName  xml.Name    `xml:"gpx"`
+	Version  string      `xml:"version,attr"`
+	Creator  string      `xml:"creator,attr"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	Metadata gpxMetadata `xml:"metadata"`
+	Tracks   []gpxTrack  `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Time string `xml:"time"`
+}
+
+type gpxTrack struct {
+	Name     string            `xml:"name"`
+	Segments []gpxTrackSegment `xml:"trkseg"`
+}
+
+type gpxTrackSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+	Name string  `xml:"name,omitempty"`
+	Desc string  `xml:"desc,omitempty"`
+	Type string  `xml:"type,omitempty"`
+}
+
+// patrolTrackGPX returns the records as a GPX 1.1 track, the block, checkpoint and geofence go into the point descriptions
+func patrolTrackGPX(guard models.User, from time.Time, points []patrolTrackPoint) gpxDocument {
+	segment := gpxTrackSegment{Points: make([]gpxPoint, 0, len(points))}
+	for _, p := range points {
+		desc := "Block " + p.Block
+		if inside := p.insideGeofence(); inside != nil {
+			if *inside {
+				desc += ", inside geofence"
+			} else {
+				desc += fmt.Sprintf(", outside geofence (%.1f m)", p.Distance)
+			}
+		}
+		segment.Points = append(segment.Points, gpxPoint{
+			Lat:  p.Latitude,
+			Lon:  p.Longitude,
+			Time: p.VisitedAt.UTC().Format(time.RFC3339),
+			Name: p.label(),
+			Desc: desc,
+			Type: p.Method,
+		})
+	}
+	name := fmt.Sprintf("Patroli %s %s", guard.Name, from.In(jakartaLocation).Format("2006-01-02"))
+	return gpxDocument{
+		Version:  "1.1",
+		Creator:  "SIMALING",
+		Xmlns:    "http://www.topografix.com/GPX/1/1",
+		Metadata: gpxMetadata{Name: name, Time: time.Now().UTC().Format(time.RFC3339)},
+		Tracks:   []gpxTrack{{Name: name, Segments: []gpxTrackSegment{segment}}},
+	}
+}
+
+// GetPatrolTrack returns the security records of a guard as a map track, ordered by time.
+//
+//	security_id  the guard (admins, required), security users always get their own track
+//	from, to     date range (YYYY-MM-DD, inclusive), defaults to the current patrol night (noon to noon)
+//	format       "geojson" (default, a FeatureCollection for Leaflet) or "gpx"
+//
+// Records without coordinates are left out.
+func GetPatrolTrack(c *gin.Context) {
+	admin := isAdmin(c)
+	if !admin && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	var securityID uint
+	if admin {
+		id, err := strconv.ParseUint(c.Query("security_id"), 10, 64)
+		if err != nil || id == 0 {
+			c.JSON(400, gin.H{"message": "security_id is required"})
+			return
+		}
+		securityID = uint(id)
+	} else {
+		securityID, _ = currentUserID(c)
+	}
+
+	format := c.DefaultQuery("format", "geojson")
+	if format != "geojson" && format != "gpx" {
+		c.JSON(400, gin.H{"message": "format must be geojson or gpx"})
+		return
+	}
+
+	night := patrolNight(time.Now())
+	from, to := night.Add(12*time.Hour), night.Add(36*time.Hour)
+	if fromStr, toStr := c.Query("from"), c.Query("to"); fromStr != "" || toStr != "" {
+		if fromStr == "" || toStr == "" {
+			c.JSON(400, gin.H{"message": "Send both from and to"})
+			return
+		}
+		var err error
+		if from, err = time.ParseInLocation("2006-01-02", fromStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid from date. Use YYYY-MM-DD."})
+			return
+		}
+		if to, err = time.ParseInLocation("2006-01-02", toStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid to date. Use YYYY-MM-DD."})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+		if !from.Before(to) {
+			c.JSON(400, gin.H{"message": "from must not be after to"})
+			return
+		}
+		if to.Sub(from) > maxPatrolTrackDays*24*time.Hour {
+			c.JSON(400, gin.H{"message": fmt.Sprintf("The date range can be at most %d days", maxPatrolTrackDays)})
+			return
+		}
+	}
+
+	var guard models.User
+	if err := initializers.DB.Select("id, name, role_id").Where("id = ?", securityID).First(&guard).Error; err != nil || guard.Role_Id != 3 {
+		c.JSON(404, gin.H{"message": "Security user not found"})
+		return
+	}
+
+	points, err := patrolTrackPoints(securityID, from, to)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get security records"})
+		return
+	}
+
+	filename := fmt.Sprintf("patrol-%d-%s", guard.ID, from.Format("20060102"))
+	if format == "gpx" {
+		body, err := xml.MarshalIndent(patrolTrackGPX(guard, from, points), "", "  ")
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Failed to build GPX"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.gpx"`)
+		c.Data(200, "application/gpx+xml", append([]byte(xml.Header), body...))
+		return
+	}
+
+	body, err := json.Marshal(patrolTrackGeoJSON(guard, from, to, points))
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to build GeoJSON"})
+		return
+	}
+	c.Header("Content-Disposition", `inline; filename="`+filename+`.geojson"`)
+	c.Data(200, "application/geo+json", body)
+}
@@ -0,0 +1,498 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// uncategorizedLabel is used for funds that were recorded without a category
+const uncategorizedLabel = "Lainnya"
+
+var indonesianMonths = [...]string{
+	"Januari", "Februari", "Maret", "April", "Mei", "Juni",
+	"Juli", "Agustus", "September", "Oktober", "November", "Desember",
+}
+
+var romanQuarters = [...]string{"I", "II", "III", "IV"}
+
+type fundsReportCategory struct {
+	Category string  `json:"category"`
+	Total    float64 `json:"total"`
+}
+
+type fundsReportTransaction struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserName    string    `json:"user_name"`
+	Block       string    `json:"block"`
+	Category    string    `json:"category"`
+	Description string    `json:"description"`
+	Is_Income   bool      `json:"is_income"`
+	Amount      float64   `json:"amount"`
+}
+
+type fundsReport struct {
+	Period            string                   `json:"period"`
+	StartDate         time.Time                `json:"start_date"`
+	EndDate           time.Time                `json:"end_date"`
+	OpeningBalance    float64                  `json:"opening_balance"`
+	TotalIncome       float64                  `json:"total_income"`
+	TotalExpense      float64                  `json:"total_expense"`
+	ClosingBalance    float64                  `json:"closing_balance"`
+	IncomeByCategory  []fundsReportCategory    `json:"income_by_category"`
+	ExpenseByCategory []fundsReportCategory    `json:"expense_by_category"`
+	Transactions      []fundsReportTransaction `json:"transactions"`
+	PreparedBy        string                   `json:"prepared_by"`
+	ApprovedBy        string                   `json:"approved_by"`
+	GeneratedAt       time.Time                `json:"generated_at"`
+
+	slug string
+}
+
+// GetFundsReport generates the kas report for a month, a quarter or a whole year.
+// Only accepted funds are taken into account, the same way the home dashboard does.
+func GetFundsReport(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	startTime, endTime, period, slug, err := parseReportPeriod(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	report, err := buildFundsReport(startTime, endTime)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to generate funds report"})
+		return
+	}
+	report.Period = period
+	report.slug = slug
+
+	// Signature block, the query parameters take precedence over the configured names
+	report.PreparedBy = c.DefaultQuery("prepared_by", initializers.AppConfig.TreasurerName)
+	report.ApprovedBy = c.DefaultQuery("approved_by", initializers.AppConfig.RTHeadName)
+	if report.PreparedBy == "" {
+		if uid, ok := c.Get("user_id"); ok {
+			var user models.User
+			if err := initializers.DB.Select("name").Where("id = ?", uid).First(&user).Error; err == nil {
+				report.PreparedBy = user.Name
+			}
+		}
+	}
+
+	// Render into a buffer first so a failure can still be reported as JSON
+	var buf bytes.Buffer
+	switch c.DefaultQuery("format", "pdf") {
+	case "json":
+		c.JSON(200, gin.H{"data": report})
+	case "xlsx":
+		if err := writeFundsReportXLSX(&buf, report); err != nil {
+			c.JSON(500, gin.H{"message": "Failed to generate funds report"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="laporan-kas-`+report.slug+`.xlsx"`)
+		c.Data(200, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+	case "pdf":
+		if err := writeFundsReportPDF(&buf, report); err != nil {
+			c.JSON(500, gin.H{"message": "Failed to generate funds report"})
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="laporan-kas-`+report.slug+`.pdf"`)
+		c.Data(200, "application/pdf", buf.Bytes())
+	default:
+		c.JSON(400, gin.H{"message": "Invalid format. Use pdf, xlsx or json."})
+	}
+}
+
+// parseReportPeriod reads year and an optional month or quarter from the query.
+// Without month or quarter the whole year is reported.
+func parseReportPeriod(c *gin.Context) (time.Time, time.Time, string, string, error) {
+	var startTime, endTime time.Time
+
+	year, err := strconv.Atoi(c.Query("year"))
+	if err != nil || year < 1 {
+		return startTime, endTime, "", "", fmt.Errorf("Invalid or missing year")
+	}
+
+	if monthStr := c.Query("month"); monthStr != "" {
+		month, err := strconv.Atoi(monthStr)
+		if err != nil || month < 1 || month > 12 {
+			return startTime, endTime, "", "", fmt.Errorf("Invalid month")
+		}
+		startTime = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+		endTime = startTime.AddDate(0, 1, 0)
+		return startTime, endTime,
+			fmt.Sprintf("Bulan %s %d", indonesianMonths[month-1], year),
+			fmt.Sprintf("%d-%02d", year, month), nil
+	}
+
+	if quarterStr := c.Query("quarter"); quarterStr != "" {
+		quarter, err := strconv.Atoi(quarterStr)
+		if err != nil || quarter < 1 || quarter > 4 {
+			return startTime, endTime, "", "", fmt.Errorf("Invalid quarter")
+		}
+		startTime = time.Date(year, time.Month((quarter-1)*3+1), 1, 0, 0, 0, 0, time.Local)
+		endTime = startTime.AddDate(0, 3, 0)
+		return startTime, endTime,
+			fmt.Sprintf("Triwulan %s %d", romanQuarters[quarter-1], year),
+			fmt.Sprintf("%d-q%d", year, quarter), nil
+	}
+
+	startTime = time.Date(year, 1, 1, 0, 0, 0, 0, time.Local)
+	endTime = startTime.AddDate(1, 0, 0)
+	return startTime, endTime, fmt.Sprintf("Tahun %d", year), strconv.Itoa(year), nil
+}
+
+// buildFundsReport collects balances, category totals and transactions of accepted funds in [startTime, endTime)
+func buildFundsReport(startTime, endTime time.Time) (fundsReport, error) {
+	report := fundsReport{
+		StartDate:   startTime,
+		EndDate:     endTime.AddDate(0, 0, -1),
+		GeneratedAt: time.Now(),
+	}
+
+	// Opening balance is everything accepted before the period starts
+	err := initializers.DB.Model(&models.Funds{}).
+		Select("COALESCE(SUM(CASE WHEN is_income THEN amount ELSE -amount END),0)").
+		Where("status = ? AND created_at < ?", "Accepted", startTime).
+		Row().Scan(&report.OpeningBalance)
+	if err != nil {
+		return report, err
+	}
+
+	var totals []struct {
+		Category  string
+		Is_Income bool
+		Total     float64
+	}
+	err = initializers.DB.Model(&models.Funds{}).
+		Select("category, is_income, SUM(amount) as total").
+		Where("status = ? AND created_at >= ? AND created_at < ?", "Accepted", startTime, endTime).
+		Group("category, is_income").
+		Scan(&totals).Error
+	if err != nil {
+		return report, err
+	}
+
+	income := make(map[string]float64)
+	expense := make(map[string]float64)
+	for _, t := range totals {
+		category := strings.TrimSpace(t.Category)
+		if category == "" {
+			category = uncategorizedLabel
+		}
+		if t.Is_Income {
+			income[category] += t.Total
+			report.TotalIncome += t.Total
+		} else {
+			expense[category] += t.Total
+			report.TotalExpense += t.Total
+		}
+	}
+	report.IncomeByCategory = sortedReportCategories(income)
+	report.ExpenseByCategory = sortedReportCategories(expense)
+	report.ClosingBalance = report.OpeningBalance + report.TotalIncome - report.TotalExpense
+
+	err = initializers.DB.Model(&models.Funds{}).
+		Select("funds.id, funds.created_at, users.name as user_name, funds.block, funds.category, funds.description, funds.is_income, funds.amount").
+		Joins("left join users on users.id = funds.user_id").
+		Where("funds.status = ? AND funds.created_at >= ? AND funds.created_at < ?", "Accepted", startTime, endTime).
+		Order("funds.created_at ASC, funds.id ASC").
+		Scan(&report.Transactions).Error
+	if err != nil {
+		return report, err
+	}
+	for i := range report.Transactions {
+		if strings.TrimSpace(report.Transactions[i].Category) == "" {
+			report.Transactions[i].Category = uncategorizedLabel
+		}
+	}
+
+	return report, nil
+}
+
+func sortedReportCategories(totals map[string]float64) []fundsReportCategory {
+	categories := make([]fundsReportCategory, 0, len(totals))
+	for category, total := range totals {
+		categories = append(categories, fundsReportCategory{Category: category, Total: total})
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Category < categories[j].Category
+	})
+	return categories
+}
+
+// formatRupiah formats an amount as "Rp 1.250.000"
+func formatRupiah(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatInt(int64(amount+0.5), 10)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(d)
+	}
+	return sign + "Rp " + b.String()
+}
+
+// formatIndonesianDate formats a date as "5 Maret 2025"
+func formatIndonesianDate(t time.Time) string {
+	return fmt.Sprintf("%d %s %d", t.Day(), indonesianMonths[t.Month()-1], t.Year())
+}
+
+func writeFundsReportPDF(w io.Writer, report fundsReport) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(15, 15, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Dicetak %s - Halaman %d/{nb}", report.GeneratedAt.Format("02/01/2006 15:04"), pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	// Title
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, "LAPORAN KAS", "", 1, "C", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(report.Period), "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 6, formatIndonesianDate(report.StartDate)+" - "+formatIndonesianDate(report.EndDate), "", 1, "C", false, 0, "")
+	pdf.Ln(6)
+
+	// Summary
+	summaryRow := func(label string, amount float64, bold bool) {
+		style := ""
+		if bold {
+			style = "B"
+		}
+		pdf.SetFont("Helvetica", style, 10)
+		pdf.CellFormat(120, 6, tr(label), "", 0, "L", false, 0, "")
+		pdf.CellFormat(60, 6, formatRupiah(amount), "", 1, "R", false, 0, "")
+	}
+	sectionTitle := func(title string) {
+		pdf.SetFont("Helvetica", "B", 11)
+		pdf.SetFillColor(230, 230, 230)
+		pdf.CellFormat(180, 7, title, "", 1, "L", true, 0, "")
+	}
+
+	summaryRow("Saldo Awal", report.OpeningBalance, true)
+	pdf.Ln(2)
+
+	sectionTitle("Pemasukan")
+	for _, line := range report.IncomeByCategory {
+		summaryRow("    "+line.Category, line.Total, false)
+	}
+	summaryRow("Total Pemasukan", report.TotalIncome, true)
+	pdf.Ln(2)
+
+	sectionTitle("Pengeluaran")
+	for _, line := range report.ExpenseByCategory {
+		summaryRow("    "+line.Category, line.Total, false)
+	}
+	summaryRow("Total Pengeluaran", report.TotalExpense, true)
+	pdf.Ln(2)
+
+	summaryRow("Saldo Akhir", report.ClosingBalance, true)
+	pdf.Ln(6)
+
+	// Transactions
+	sectionTitle("Rincian Transaksi")
+	widths := []float64{22, 35, 15, 28, 50, 30}
+	headers := []string{"Tanggal", "Nama", "Blok", "Kategori", "Keterangan", "Jumlah"}
+	pdf.SetFont("Helvetica", "B", 9)
+	for i, h := range headers {
+		pdf.CellFormat(widths[i], 6, h, "1", 0, "C", false, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 8)
+	for _, t := range report.Transactions {
+		amount := formatRupiah(t.Amount)
+		if !t.Is_Income {
+			amount = "(" + amount + ")"
+		}
+		cells := []string{
+			t.CreatedAt.Format("02/01/2006"),
+			truncateForCell(t.UserName, 22),
+			truncateForCell(t.Block, 8),
+			truncateForCell(t.Category, 18),
+			truncateForCell(t.Description, 34),
+			amount,
+		}
+		for i, v := range cells {
+			align := "L"
+			if i == len(cells)-1 {
+				align = "R"
+			}
+			pdf.CellFormat(widths[i], 6, tr(v), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+	if len(report.Transactions) == 0 {
+		pdf.CellFormat(180, 6, "Tidak ada transaksi pada periode ini", "1", 1, "C", false, 0, "")
+	}
+
+	// Signatures
+	if pdf.GetY() > 240 {
+		pdf.AddPage()
+	}
+	pdf.Ln(12)
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(90, 6, "Dibuat oleh,", "", 0, "C", false, 0, "")
+	pdf.CellFormat(90, 6, "Disetujui oleh,", "", 1, "C", false, 0, "")
+	pdf.CellFormat(90, 6, "Bendahara", "", 0, "C", false, 0, "")
+	pdf.CellFormat(90, 6, "Ketua RT", "", 1, "C", false, 0, "")
+	pdf.Ln(20)
+	pdf.SetFont("Helvetica", "BU", 10)
+	pdf.CellFormat(90, 6, tr(signatureName(report.PreparedBy)), "", 0, "C", false, 0, "")
+	pdf.CellFormat(90, 6, tr(signatureName(report.ApprovedBy)), "", 1, "C", false, 0, "")
+
+	return pdf.Output(w)
+}
+
+func writeFundsReportXLSX(w io.Writer, report fundsReport) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	const summarySheet = "Ringkasan"
+	const transactionSheet = "Transaksi"
+	if err := f.SetSheetName("Sheet1", summarySheet); err != nil {
+		return err
+	}
+	if _, err := f.NewSheet(transactionSheet); err != nil {
+		return err
+	}
+
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	// Built-in number format 3 is "#,##0"
+	money, err := f.NewStyle(&excelize.Style{NumFmt: 3})
+	if err != nil {
+		return err
+	}
+	boldMoney, err := f.NewStyle(&excelize.Style{NumFmt: 3, Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+
+	// Summary sheet
+	row := 1
+	setRow := func(sheet string, values ...interface{}) {
+		for i, v := range values {
+			cell, _ := excelize.CoordinatesToCellName(i+1, row)
+			f.SetCellValue(sheet, cell, v)
+		}
+		row++
+	}
+	styleRow := func(sheet string, style int, cols int) {
+		first, _ := excelize.CoordinatesToCellName(1, row-1)
+		last, _ := excelize.CoordinatesToCellName(cols, row-1)
+		f.SetCellStyle(sheet, first, last, style)
+	}
+	moneyCell := func(sheet string, col int, style int) {
+		cell, _ := excelize.CoordinatesToCellName(col, row-1)
+		f.SetCellStyle(sheet, cell, cell, style)
+	}
+
+	setRow(summarySheet, "LAPORAN KAS")
+	styleRow(summarySheet, bold, 1)
+	setRow(summarySheet, report.Period)
+	setRow(summarySheet, formatIndonesianDate(report.StartDate)+" - "+formatIndonesianDate(report.EndDate))
+	row++
+
+	setRow(summarySheet, "Saldo Awal", report.OpeningBalance)
+	styleRow(summarySheet, bold, 1)
+	moneyCell(summarySheet, 2, boldMoney)
+	row++
+
+	setRow(summarySheet, "Pemasukan")
+	styleRow(summarySheet, bold, 1)
+	for _, line := range report.IncomeByCategory {
+		setRow(summarySheet, line.Category, line.Total)
+		moneyCell(summarySheet, 2, money)
+	}
+	setRow(summarySheet, "Total Pemasukan", report.TotalIncome)
+	styleRow(summarySheet, bold, 1)
+	moneyCell(summarySheet, 2, boldMoney)
+	row++
+
+	setRow(summarySheet, "Pengeluaran")
+	styleRow(summarySheet, bold, 1)
+	for _, line := range report.ExpenseByCategory {
+		setRow(summarySheet, line.Category, line.Total)
+		moneyCell(summarySheet, 2, money)
+	}
+	setRow(summarySheet, "Total Pengeluaran", report.TotalExpense)
+	styleRow(summarySheet, bold, 1)
+	moneyCell(summarySheet, 2, boldMoney)
+	row++
+
+	setRow(summarySheet, "Saldo Akhir", report.ClosingBalance)
+	styleRow(summarySheet, bold, 1)
+	moneyCell(summarySheet, 2, boldMoney)
+	row += 2
+
+	setRow(summarySheet, "Dibuat oleh (Bendahara)", signatureName(report.PreparedBy))
+	setRow(summarySheet, "Disetujui oleh (Ketua RT)", signatureName(report.ApprovedBy))
+	setRow(summarySheet, "Dicetak", report.GeneratedAt.Format("02/01/2006 15:04"))
+	f.SetColWidth(summarySheet, "A", "A", 32)
+	f.SetColWidth(summarySheet, "B", "B", 20)
+
+	// Transaction sheet
+	row = 1
+	setRow(transactionSheet, "No", "Tanggal", "Nama", "Blok", "Kategori", "Keterangan", "Jenis", "Jumlah")
+	styleRow(transactionSheet, bold, 8)
+	for i, t := range report.Transactions {
+		kind := "Pemasukan"
+		if !t.Is_Income {
+			kind = "Pengeluaran"
+		}
+		setRow(transactionSheet, i+1, t.CreatedAt.Format("02/01/2006"), t.UserName, t.Block, t.Category, t.Description, kind, t.Amount)
+		moneyCell(transactionSheet, 8, money)
+	}
+	f.SetColWidth(transactionSheet, "B", "B", 12)
+	f.SetColWidth(transactionSheet, "C", "C", 24)
+	f.SetColWidth(transactionSheet, "E", "E", 18)
+	f.SetColWidth(transactionSheet, "F", "F", 40)
+	f.SetColWidth(transactionSheet, "G", "H", 14)
+
+	return f.Write(w)
+}
+
+// signatureName leaves a blank line to sign on when no name is known
+func signatureName(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return "(____________________)"
+	}
+	return name
+}
+
+func truncateForCell(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-3]) + "..."
+}
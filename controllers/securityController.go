@@ -1,15 +1,118 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"mime/multipart"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dontkeep/simaling-backend/events"
 	"github.com/dontkeep/simaling-backend/initializers"
 	"github.com/dontkeep/simaling-backend/models"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
 )
 
+// securityRecordExportSelect selects the columns scanned into securityRecordExportRow by the CSV/XLSX exports
+const securityRecordExportSelect = "security_records.id, security_records.created_at, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude" + securityRecordFlagsSelect
+
+type securityRecordExportRow struct {
+	ID              uint
+	CreatedAt       time.Time
+	SecurityName    string
+	Block           string
+	PhoneNo         string
+	Longitude       *float64
+	Latitude        *float64
+	CheckpointName  string
+	Distance        float64
+	Accuracy        float64
+	OutsideGeofence bool
+	LowAccuracy     bool
+	OutsideShift    bool
+	Method          string
+	ScannedAt       *time.Time
+	CapturedAt      *time.Time
+	LateSync        bool
+	SyncReviewedAt  *time.Time
+	Purpose         string
+}
+
+var securityRecordExportColumns = []exportColumn[securityRecordExportRow]{
+	{"ID", "ID", func(r securityRecordExportRow, _ string) interface{} { return r.ID }},
+	{"Waktu", "Time", func(r securityRecordExportRow, _ string) interface{} { return exportTime(r.CreatedAt) }},
+	{"Petugas", "Security Officer", func(r securityRecordExportRow, _ string) interface{} { return r.SecurityName }},
+	{"Blok", "Block", func(r securityRecordExportRow, _ string) interface{} { return r.Block }},
+	{"No. HP", "Phone No.", func(r securityRecordExportRow, _ string) interface{} { return r.PhoneNo }},
+	{"Bujur", "Longitude", func(r securityRecordExportRow, _ string) interface{} { return exportOptionalFloat(r.Longitude) }},
+	{"Lintang", "Latitude", func(r securityRecordExportRow, _ string) interface{} { return exportOptionalFloat(r.Latitude) }},
+	{"Titik Patroli", "Checkpoint", func(r securityRecordExportRow, _ string) interface{} { return r.CheckpointName }},
+	{"Jarak (m)", "Distance (m)", func(r securityRecordExportRow, _ string) interface{} { return r.Distance }},
+	{"Akurasi GPS (m)", "GPS Accuracy (m)", func(r securityRecordExportRow, _ string) interface{} { return r.Accuracy }},
+	{"Di Luar Area", "Outside Geofence", func(r securityRecordExportRow, lang string) interface{} { return yesNo(r.OutsideGeofence, lang) }},
+	{"Akurasi Rendah", "Low Accuracy", func(r securityRecordExportRow, lang string) interface{} { return yesNo(r.LowAccuracy, lang) }},
+	{"Di Luar Shift", "Outside Shift", func(r securityRecordExportRow, lang string) interface{} { return yesNo(r.OutsideShift, lang) }},
+	{"Metode", "Method", func(r securityRecordExportRow, _ string) interface{} { return r.Method }},
+	{"Keperluan", "Purpose", func(r securityRecordExportRow, lang string) interface{} {
+		if r.Purpose == securityRecordResidentVisit {
+			return localize(lang, "Kunjungan Warga", "Resident Visit")
+		}
+		return localize(lang, "Patroli", "Patrol")
+	}},
+	{"Waktu Pindai", "Scan Time", func(r securityRecordExportRow, _ string) interface{} {
+		if r.ScannedAt == nil {
+			return ""
+		}
+		return exportTime(*r.ScannedAt)
+	}},
+	{"Waktu Rekam (Offline)", "Captured At (Offline)", func(r securityRecordExportRow, _ string) interface{} {
+		if r.CapturedAt == nil {
+			return ""
+		}
+		return exportTime(*r.CapturedAt)
+	}},
+	{"Sinkron Terlambat", "Late Sync", func(r securityRecordExportRow, lang string) interface{} {
+		if r.LateSync && r.SyncReviewedAt != nil {
+			return localize(lang, "Sudah Diperiksa", "Reviewed")
+		}
+		return yesNo(r.LateSync, lang)
+	}},
+}
+
+// securityRecordFlaggedWhere matches the records an admin should look at
+const securityRecordFlaggedWhere = "security_records.outside_geofence = ? OR security_records.low_accuracy = ? OR security_records.outside_shift = ? " +
+	"OR (security_records.late_sync = ? AND security_records.sync_reviewed_at IS NULL)"
+
+// securityRecordFlagsSelect adds the checkpoint match and the flags of a record to the list selects,
+// it needs the securityRecordCheckpointJoin join
+const securityRecordFlagsSelect = ", security_records.checkpoint_id, checkpoints.name as checkpoint_name, security_records.distance, security_records.accuracy, security_records.outside_geofence, security_records.low_accuracy, security_records.outside_shift, security_records.method, security_records.scanned_at, security_records.captured_at, security_records.late_sync, security_records.sync_reviewed_at, security_records.purpose, security_records.resident_id"
+
+const securityRecordCheckpointJoin = "left join checkpoints on checkpoints.id = security_records.checkpoint_id"
+
+// securityRecordOrder lists security records newest first, the id keeps cursors unique
+var securityRecordOrder = sortOrder{
+	{field: sortField{Key: "created_at", Column: "security_records.created_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "security_records.id"}, desc: true},
+}
+
+// publishSecurityRecord sends a new security record to the staff, and to the residents of its block
+// as residentData, which must leave out the photos since they may show people and houses.
+// A resident visit carries the phone number of the visited resident, only that resident hears of it.
+func publishSecurityRecord(record models.SecurityRecord, data, residentData interface{}) {
+	publishEvent("security_record.created", data, staffAudience())
+	residents := events.Audience{BlockRoles: []uint{2}, Block: record.Block}
+	if record.Purpose == securityRecordResidentVisit {
+		if record.Resident_Id == nil {
+			return
+		}
+		residents = events.Audience{Users: []uint{*record.Resident_Id}}
+	}
+	publishEvent("security_record.created", residentData, residents)
+}
+
 // userVerification checks if the current user is a normal user (role_id == 2)
 func userVerification(c *gin.Context) bool {
 	userID, exists := c.Get("user_id")
@@ -59,19 +162,34 @@ func GetScurityRecordToday(c *gin.Context) {
 	}
 
 	type SecurityRecordResponse struct {
-		ID           uint   `json:"id"`
-		SecurityId   uint   `json:"security_id"`
-		SecurityName string `json:"security_name"`
-		Block        string `json:"block"`
-		PhoneNo      string `json:"phone_no"`
-		Longitude    string `json:"longitude"`
-		Latitude     string `json:"latitude"`
+		ID              uint           `json:"id"`
+		SecurityId      uint           `json:"security_id"`
+		SecurityName    string         `json:"security_name"`
+		Block           string         `json:"block"`
+		PhoneNo         string         `json:"phone_no"`
+		Longitude       coordinateText `json:"longitude"`
+		Latitude        coordinateText `json:"latitude"`
+		CheckpointId    *uint          `json:"checkpoint_id"`
+		CheckpointName  string         `json:"checkpoint_name"`
+		Distance        float64        `json:"distance"`
+		Accuracy        float64        `json:"accuracy"`
+		OutsideGeofence bool           `json:"outside_geofence"`
+		LowAccuracy     bool           `json:"low_accuracy"`
+		OutsideShift    bool           `json:"outside_shift"`
+		Method          string         `json:"method"`
+		Purpose         string         `json:"purpose"`
+		ResidentId      *uint          `json:"resident_id"`
+		ScannedAt       *time.Time     `json:"scanned_at"`
+		CapturedAt      *time.Time     `json:"captured_at"`
+		LateSync        bool           `json:"late_sync"`
+		SyncReviewedAt  *time.Time     `json:"sync_reviewed_at"`
 	}
 
 	var records []SecurityRecordResponse
 	result := initializers.DB.Model(&models.SecurityRecord{}).
-		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude").
+		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude"+securityRecordFlagsSelect).
 		Joins("left join users on users.id = security_records.security_id").
+		Joins(securityRecordCheckpointJoin).
 		Where("security_records.phone_no = ? AND DATE(security_records.created_at) = ?", user.Phone_No, today).
 		Order("security_records.created_at DESC").
 		Scan(&records)
@@ -89,28 +207,12 @@ func GetAllSecurityRecord(c *gin.Context) {
 		return
 	}
 
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")
-	limit := c.DefaultQuery("limit", "10")
-
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid page number",
-		})
-		return
-	}
-
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid limit number",
-		})
+	pagination, err := parsePagination(c, securityRecordOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	offset := (pageInt - 1) * limitInt
-
 	// Month/year filter
 	monthStr := c.Query("month")
 	yearStr := c.Query("year")
@@ -125,47 +227,95 @@ func GetAllSecurityRecord(c *gin.Context) {
 			useDateFilter = true
 		}
 	}
+	spatial, err := parseSpatialFilter(c)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
 
 	type SecurityRecordResponse struct {
-		ID           uint      `json:"id"`
-		SecurityId   uint      `json:"security_id"`
-		SecurityName string    `json:"security_name"`
-		Block        string    `json:"block"`
-		PhoneNo      string    `json:"phone_no"`
-		Longitude    string    `json:"longitude"`
-		Latitude     string    `json:"latitude"`
-		CreatedAt    time.Time `json:"created_at"`
+		ID              uint                          `json:"id"`
+		SecurityId      uint                          `json:"security_id"`
+		SecurityName    string                        `json:"security_name"`
+		Block           string                        `json:"block"`
+		PhoneNo         string                        `json:"phone_no"`
+		Longitude       coordinateText                `json:"longitude"`
+		Latitude        coordinateText                `json:"latitude"`
+		CreatedAt       time.Time                     `json:"created_at"`
+		CheckpointId    *uint                         `json:"checkpoint_id"`
+		CheckpointName  string                        `json:"checkpoint_name"`
+		Distance        float64                       `json:"distance"`
+		Accuracy        float64                       `json:"accuracy"`
+		OutsideGeofence bool                          `json:"outside_geofence"`
+		LowAccuracy     bool                          `json:"low_accuracy"`
+		OutsideShift    bool                          `json:"outside_shift"`
+		Method          string                        `json:"method"`
+		Purpose         string                        `json:"purpose"`
+		ResidentId      *uint                         `json:"resident_id"`
+		ScannedAt       *time.Time                    `json:"scanned_at"`
+		CapturedAt      *time.Time                    `json:"captured_at"`
+		LateSync        bool                          `json:"late_sync"`
+		SyncReviewedAt  *time.Time                    `json:"sync_reviewed_at"`
+		Photos          []securityRecordPhotoResponse `json:"photos" gorm:"-"`
 	}
 
 	var records []SecurityRecordResponse
 	db := initializers.DB.Model(&models.SecurityRecord{}).
-		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at").
-		Joins("left join users on users.id = security_records.security_id")
+		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at" + securityRecordFlagsSelect).
+		Joins("left join users on users.id = security_records.security_id").
+		Joins(securityRecordCheckpointJoin)
 	if useDateFilter {
 		db = db.Where("security_records.created_at >= ? AND security_records.created_at < ?", startTime, endTime)
 	}
-	db = db.Order("security_records.created_at DESC").Limit(limitInt).Offset(offset)
+	// ?flagged=true lists only records outside the geofence or a shift, with a poor GPS accuracy
+	// or synced late and not reviewed yet
+	flagged := c.Query("flagged") == "true"
+	if flagged {
+		db = db.Where(securityRecordFlaggedWhere, true, true, true, true)
+	}
+	// ?near=lat,lng&radius=R and ?polygon=lat,lng;... keep only the records in that area
+	db = spatial.apply(db)
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "security-records", db.Select(securityRecordExportSelect).Order("security_records.created_at DESC"), securityRecordExportColumns)
+		return
+	}
+	db = pagination.apply(db)
 	result := db.Scan(&records)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get security records"})
 		return
 	}
-
-	// Count the total number of security records (with filter if applied)
-	var total int64
-	totalDB := initializers.DB.Model(&models.SecurityRecord{})
-	if useDateFilter {
-		totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+	ids := make([]uint, len(records))
+	for i := range records {
+		ids[i] = records[i].ID
+	}
+	photos, err := securityRecordPhotos(ids)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get security record photos"})
+		return
+	}
+	for i := range records {
+		records[i].Photos = photos[records[i].ID]
+		if records[i].Photos == nil {
+			records[i].Photos = []securityRecordPhotoResponse{}
+		}
 	}
-	totalDB.Count(&total)
 
-	c.JSON(200, gin.H{
-		"data":       records,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt),
+	// Count the total number of security records (with filter if applied)
+	response := paginate(pagination, records, func() int64 {
+		var total int64
+		totalDB := initializers.DB.Model(&models.SecurityRecord{})
+		if useDateFilter {
+			totalDB = totalDB.Where("created_at >= ? AND created_at < ?", startTime, endTime)
+		}
+		if flagged {
+			totalDB = totalDB.Where(securityRecordFlaggedWhere, true, true, true, true)
+		}
+		totalDB = spatial.apply(totalDB)
+		totalDB.Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 func GetSecurityRecordByPhoneNum(c *gin.Context) {
@@ -241,86 +391,190 @@ func GetSecurityRecordByPhoneNum(c *gin.Context) {
 	})
 }
 
-// only security can create security record
-func CreateSecurityRecord(c *gin.Context) {
-	// Get the role from the context
-	role := c.MustGet("role").(string)
-	if role != "security" {
-		c.JSON(403, gin.H{
-			"message": "Forbidden",
-		})
-		return
-	}
+// Purpose of a security record
+const (
+	securityRecordPatrol        = "patrol"
+	securityRecordResidentVisit = "resident_visit"
+)
+
+// securityRecordInput is the body of the security record endpoints, as JSON or as a multipart form with "photos" files
+type securityRecordInput struct {
+	Purpose    string   `json:"purpose" form:"purpose"`         // "patrol" (default) or "resident_visit"
+	ResidentId uint     `json:"resident_id" form:"resident_id"` // the resident whose house was visited
+	Phone_No   string   `json:"phone_no" form:"phone_no"`       // or the phone number of that resident
+	Block      string   `json:"block" form:"block"`
+	Longitude  *float64 `json:"longitude" form:"longitude"` // required, a missing value must not read as 0,0
+	Latitude   *float64 `json:"latitude" form:"latitude"`
+	Accuracy   float64  `json:"accuracy" form:"accuracy"`   // GPS accuracy in meters as reported by the phone
+	PhotoIds   []uint   `json:"photo_ids" form:"photo_ids"` // photos uploaded ahead with POST /security-records/photos
+	Caption    string   `json:"caption" form:"caption"`     // caption of the photos sent with the form
+}
 
-	// Parse the request body
-	var body struct {
-		Block     string  `json:"block"`
-		Phone_No  string  `json:"phone_no"`
-		Longitude float64 `json:"longitude"`
-		Latitude  float64 `json:"latitude"`
+// bindSecurityRecordInput reads the body as JSON or, with the photos, as a multipart form
+func bindSecurityRecordInput(c *gin.Context) (securityRecordInput, []*multipart.FileHeader, bool) {
+	var input securityRecordInput
+	var photoFiles []*multipart.FileHeader
+	if c.ContentType() == gin.MIMEMultipartPOSTForm {
+		if err := c.ShouldBindWith(&input, binding.FormMultipart); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid request body"})
+			return input, nil, false
+		}
+		photoFiles = c.Request.MultipartForm.File["photos"]
+	} else if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return input, nil, false
 	}
+	input.PhotoIds = uniquePhotoIDs(input.PhotoIds)
+	return input, photoFiles, true
+}
 
-	if err := c.BindJSON(&body); err != nil {
-		c.JSON(400, gin.H{
-			"message": "Invalid request body",
-		})
+// createSecurityRecord saves a GPS security record for the authenticated guard and writes the response.
+// A patrol visit keeps the phone number of the guard, a visit to a resident's house keeps the resident,
+// their phone number (so the resident sees it in their records) and by default their block.
+func createSecurityRecord(c *gin.Context, input securityRecordInput, photoFiles []*multipart.FileHeader) {
+	if input.Purpose == "" {
+		input.Purpose = securityRecordPatrol
+	}
+	if input.Purpose != securityRecordPatrol && input.Purpose != securityRecordResidentVisit {
+		c.JSON(400, gin.H{"message": "purpose must be patrol or resident_visit"})
 		return
 	}
-
-	// Get security user id from context
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(400, gin.H{"message": "User not authenticated"})
+	if input.Latitude == nil || input.Longitude == nil {
+		c.JSON(400, gin.H{"message": "latitude and longitude are required"})
 		return
 	}
-	securityID, ok := userID.(uint)
+	if !validCoordinates(*input.Latitude, *input.Longitude) {
+		c.JSON(400, gin.H{"message": "Invalid coordinates"})
+		return
+	}
+	if len(photoFiles)+len(input.PhotoIds) > maxSecurityRecordPhotos {
+		c.JSON(400, gin.H{"message": fmt.Sprintf("A security record can have at most %d photos", maxSecurityRecordPhotos)})
+		return
+	}
+
+	securityID, ok := currentUserID(c)
 	if !ok {
-		c.JSON(400, gin.H{"message": "Invalid user ID"})
+		c.JSON(400, gin.H{"message": "User not authenticated"})
 		return
 	}
 
-	// Get security name
+	// Get security user (for name and phone_no)
 	var securityUser models.User
-	if err := initializers.DB.Select("name").Where("id = ?", securityID).First(&securityUser).Error; err != nil {
+	if err := initializers.DB.Select("name, phone_no").Where("id = ?", securityID).First(&securityUser).Error; err != nil {
 		c.JSON(400, gin.H{"message": "Security user not found"})
 		return
 	}
 
-	// Create the security record
 	securityRecord := models.SecurityRecord{
 		Security_Id: securityID,
-		Block:       body.Block,
-		Phone_No:    body.Phone_No,
-		Longitude:   fmt.Sprintf("%f", body.Longitude),
-		Latitude:    fmt.Sprintf("%f", body.Latitude),
+		Block:       input.Block,
+		Phone_No:    securityUser.Phone_No,
+		Longitude:   input.Longitude,
+		Latitude:    input.Latitude,
+		Purpose:     input.Purpose,
+	}
+	var resident models.User
+	if input.Purpose == securityRecordResidentVisit {
+		db := initializers.DB.Select("id, name, phone_no, block").Where("role_id = ?", 2)
+		switch {
+		case input.ResidentId != 0:
+			db = db.Where("id = ?", input.ResidentId)
+		case input.Phone_No != "":
+			db = db.Where("phone_no = ?", input.Phone_No)
+		default:
+			c.JSON(400, gin.H{"message": "resident_id or phone_no is required for a resident visit"})
+			return
+		}
+		if err := db.First(&resident).Error; err != nil {
+			c.JSON(400, gin.H{"message": "Resident not found"})
+			return
+		}
+		securityRecord.Resident_Id = &resident.ID
+		securityRecord.Phone_No = resident.Phone_No
+		if securityRecord.Block == "" {
+			securityRecord.Block = resident.Block
+		}
+	}
+	if err := applyGeofence(&securityRecord, *input.Latitude, *input.Longitude, input.Accuracy); err != nil {
+		c.JSON(500, gin.H{"message": "Failed to match checkpoint"})
+		return
 	}
+	securityRecord.Outside_Shift = !onShift(securityID, time.Now())
 
-	// Save the record to the database
-	if err := initializers.DB.Create(&securityRecord).Error; err != nil {
-		c.JSON(500, gin.H{
-			"message": "Failed to create security record",
-		})
+	uploaded, err := saveSecurityRecordPhotoFiles(c, photoFiles, strings.TrimSpace(input.Caption))
+	if err != nil {
+		if message := uploadErrorMessage(err); message != "" {
+			c.JSON(400, gin.H{"message": message})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to save photo"})
 		return
 	}
 
+	// Save the record and its photos to the database
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&securityRecord).Error; err != nil {
+			return err
+		}
+		return attachSecurityRecordPhotos(tx, securityRecord.ID, securityID, uploaded, input.PhotoIds)
+	})
+	if err != nil {
+		deleteSecurityRecordPhotoFiles(c, uploaded)
+		if errors.Is(err, errInvalidRecordPhotos) {
+			c.JSON(400, gin.H{"message": "Unknown, used or expired photo_ids, upload the photos again"})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to create security record"})
+		return
+	}
+	photos, _ := securityRecordPhotos([]uint{securityRecord.ID})
+
 	// Prepare response
 	response := struct {
-		ID           uint   `json:"id"`
-		SecurityId   uint   `json:"security_id"`
-		SecurityName string `json:"security_name"`
-		Block        string `json:"block"`
-		PhoneNo      string `json:"phone_no"`
-		Longitude    string `json:"longitude"`
-		Latitude     string `json:"latitude"`
+		ID              uint                          `json:"id"`
+		SecurityId      uint                          `json:"security_id"`
+		SecurityName    string                        `json:"security_name"`
+		Purpose         string                        `json:"purpose"`
+		ResidentId      *uint                         `json:"resident_id"`
+		ResidentName    string                        `json:"resident_name"`
+		Block           string                        `json:"block"`
+		PhoneNo         string                        `json:"phone_no"`
+		Longitude       coordinateText                `json:"longitude"`
+		Latitude        coordinateText                `json:"latitude"`
+		CheckpointId    *uint                         `json:"checkpoint_id"`
+		Distance        float64                       `json:"distance"`
+		Accuracy        float64                       `json:"accuracy"`
+		OutsideGeofence bool                          `json:"outside_geofence"`
+		LowAccuracy     bool                          `json:"low_accuracy"`
+		OutsideShift    bool                          `json:"outside_shift"`
+		Photos          []securityRecordPhotoResponse `json:"photos"`
+		CreatedAt       time.Time                     `json:"created_at"`
 	}{
-		ID:           securityRecord.ID,
-		SecurityId:   securityID,
-		SecurityName: securityUser.Name,
-		Block:        securityRecord.Block,
-		PhoneNo:      securityRecord.Phone_No,
-		Longitude:    securityRecord.Longitude,
-		Latitude:     securityRecord.Latitude,
-	}
+		ID:              securityRecord.ID,
+		SecurityId:      securityID,
+		SecurityName:    securityUser.Name,
+		Purpose:         securityRecord.Purpose,
+		ResidentId:      securityRecord.Resident_Id,
+		ResidentName:    resident.Name,
+		Block:           securityRecord.Block,
+		PhoneNo:         securityRecord.Phone_No,
+		Longitude:       formatCoordinate(securityRecord.Longitude),
+		Latitude:        formatCoordinate(securityRecord.Latitude),
+		CheckpointId:    securityRecord.Checkpoint_Id,
+		Distance:        securityRecord.Distance,
+		Accuracy:        securityRecord.Accuracy,
+		OutsideGeofence: securityRecord.Outside_Geofence,
+		LowAccuracy:     securityRecord.Low_Accuracy,
+		OutsideShift:    securityRecord.Outside_Shift,
+		Photos:          photos[securityRecord.ID],
+		CreatedAt:       securityRecord.CreatedAt,
+	}
+	if response.Photos == nil {
+		response.Photos = []securityRecordPhotoResponse{}
+	}
+	residentEvent := response
+	residentEvent.Photos = []securityRecordPhotoResponse{}
+	publishSecurityRecord(securityRecord, response, residentEvent)
 
 	c.JSON(200, gin.H{
 		"message": "Security record created successfully",
@@ -328,81 +582,51 @@ func CreateSecurityRecord(c *gin.Context) {
 	})
 }
 
-func AddSecurityRecord(c *gin.Context) {
+// CreateSecurityRecordV2 records a patrol visit or, with "purpose": "resident_visit" and resident_id (or the
+// resident's phone_no), a visit to a resident's house. It replaces the deprecated POST /security-records and
+// POST /security-records/add.
+func CreateSecurityRecordV2(c *gin.Context) {
 	if !isSecurity(c) {
 		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
 		return
 	}
-
-	// Parse the request body (only block, longitude, latitude)
-	var body struct {
-		Block     string  `json:"block"`
-		Longitude float64 `json:"longitude"`
-		Latitude  float64 `json:"latitude"`
-	}
-
-	if err := c.BindJSON(&body); err != nil {
-		c.JSON(400, gin.H{"message": "Invalid request body"})
+	input, photoFiles, ok := bindSecurityRecordInput(c)
+	if !ok {
 		return
 	}
+	createSecurityRecord(c, input, photoFiles)
+}
 
-	// Get security user id from context
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(400, gin.H{"message": "User not authenticated"})
+// CreateSecurityRecord is the deprecated first version of the endpoint, the phone_no in the body is the
+// resident that was visited. Without phone_no it records a patrol visit.
+func CreateSecurityRecord(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden"})
 		return
 	}
-	securityID, ok := userID.(uint)
+	input, photoFiles, ok := bindSecurityRecordInput(c)
 	if !ok {
-		c.JSON(400, gin.H{"message": "Invalid user ID"})
 		return
 	}
-
-	// Get security user (for name and phone_no)
-	var securityUser models.User
-	if err := initializers.DB.Select("name, phone_no").Where("id = ?", securityID).First(&securityUser).Error; err != nil {
-		c.JSON(400, gin.H{"message": "Security user not found"})
-		return
-	}
-
-	// Create the security record
-	securityRecord := models.SecurityRecord{
-		Security_Id: securityID,
-		Block:       body.Block,
-		Phone_No:    securityUser.Phone_No,
-		Longitude:   fmt.Sprintf("%f", body.Longitude),
-		Latitude:    fmt.Sprintf("%f", body.Latitude),
+	input.Purpose, input.ResidentId = securityRecordPatrol, 0
+	if input.Phone_No != "" {
+		input.Purpose = securityRecordResidentVisit
 	}
+	createSecurityRecord(c, input, photoFiles)
+}
 
-	// Save the record to the database
-	if err := initializers.DB.Create(&securityRecord).Error; err != nil {
-		c.JSON(500, gin.H{"message": "Failed to create security record"})
+// AddSecurityRecord is the deprecated patrol-only endpoint, it always keeps the guard's phone number
+func AddSecurityRecord(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
 		return
 	}
-
-	// Prepare response
-	response := struct {
-		ID           uint   `json:"id"`
-		SecurityId   uint   `json:"security_id"`
-		SecurityName string `json:"security_name"`
-		Block        string `json:"block"`
-		PhoneNo      string `json:"phone_no"`
-		Longitude    string `json:"longitude"`
-		Latitude     string `json:"latitude"`
-	}{
-		ID:           securityRecord.ID,
-		SecurityId:   securityID,
-		SecurityName: securityUser.Name,
-		Block:        securityRecord.Block,
-		PhoneNo:      securityUser.Phone_No,
-		Longitude:    securityRecord.Longitude,
-		Latitude:     securityRecord.Latitude,
+	input, photoFiles, ok := bindSecurityRecordInput(c)
+	if !ok {
+		return
 	}
-
-	c.JSON(200, gin.H{
-		"message": "Security record created successfully",
-		"data":    response,
-	})
+	input.Purpose, input.ResidentId, input.Phone_No = securityRecordPatrol, 0, ""
+	createSecurityRecord(c, input, photoFiles)
 }
 
 func DeleteSecurityRecord(c *gin.Context) {
@@ -497,23 +721,44 @@ func GetSecurityRecordByDay(c *gin.Context) {
 	}
 
 	type SecurityRecordResponse struct {
-		ID           uint      `json:"id"`
-		SecurityId   uint      `json:"security_id"`
-		SecurityName string    `json:"security_name"`
-		Block        string    `json:"block"`
-		PhoneNo      string    `json:"phone_no"`
-		Longitude    string    `json:"longitude"`
-		Latitude     string    `json:"latitude"`
-		CreatedAt    time.Time `json:"created_at"`
+		ID              uint           `json:"id"`
+		SecurityId      uint           `json:"security_id"`
+		SecurityName    string         `json:"security_name"`
+		Block           string         `json:"block"`
+		PhoneNo         string         `json:"phone_no"`
+		Longitude       coordinateText `json:"longitude"`
+		Latitude        coordinateText `json:"latitude"`
+		CreatedAt       time.Time      `json:"created_at"`
+		CheckpointId    *uint          `json:"checkpoint_id"`
+		CheckpointName  string         `json:"checkpoint_name"`
+		Distance        float64        `json:"distance"`
+		Accuracy        float64        `json:"accuracy"`
+		OutsideGeofence bool           `json:"outside_geofence"`
+		LowAccuracy     bool           `json:"low_accuracy"`
+		OutsideShift    bool           `json:"outside_shift"`
+		Method          string         `json:"method"`
+		Purpose         string         `json:"purpose"`
+		ResidentId      *uint          `json:"resident_id"`
+		ScannedAt       *time.Time     `json:"scanned_at"`
+		CapturedAt      *time.Time     `json:"captured_at"`
+		LateSync        bool           `json:"late_sync"`
+		SyncReviewedAt  *time.Time     `json:"sync_reviewed_at"`
 	}
 
 	var records []SecurityRecordResponse
-	result := initializers.DB.Model(&models.SecurityRecord{}).
-		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at").
+	db := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at"+securityRecordFlagsSelect).
 		Joins("left join users on users.id = security_records.security_id").
+		Joins(securityRecordCheckpointJoin).
 		Where("security_records.created_at >= ? AND security_records.created_at < ?", startOfDay, endOfDay).
-		Order("security_records.created_at DESC").
-		Scan(&records)
+		// Visits to other residents carry their phone number, so a resident only sees their own
+		Where("security_records.purpose <> ? OR security_records.resident_id = ?", securityRecordResidentVisit, uid).
+		Order("security_records.created_at DESC")
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "security-records-by-day", db.Select(securityRecordExportSelect), securityRecordExportColumns)
+		return
+	}
+	result := db.Scan(&records)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get security records for the specified day"})
 		return
@@ -542,23 +787,42 @@ func GetSecurityRecordByUser(c *gin.Context) {
 	}
 
 	type SecurityRecordResponse struct {
-		ID           uint      `json:"id"`
-		SecurityId   uint      `json:"security_id"`
-		SecurityName string    `json:"security_name"`
-		Block        string    `json:"block"`
-		PhoneNo      string    `json:"phone_no"`
-		Longitude    string    `json:"longitude"`
-		Latitude     string    `json:"latitude"`
-		CreatedAt    time.Time `json:"created_at"`
+		ID              uint           `json:"id"`
+		SecurityId      uint           `json:"security_id"`
+		SecurityName    string         `json:"security_name"`
+		Block           string         `json:"block"`
+		PhoneNo         string         `json:"phone_no"`
+		Longitude       coordinateText `json:"longitude"`
+		Latitude        coordinateText `json:"latitude"`
+		CreatedAt       time.Time      `json:"created_at"`
+		CheckpointId    *uint          `json:"checkpoint_id"`
+		CheckpointName  string         `json:"checkpoint_name"`
+		Distance        float64        `json:"distance"`
+		Accuracy        float64        `json:"accuracy"`
+		OutsideGeofence bool           `json:"outside_geofence"`
+		LowAccuracy     bool           `json:"low_accuracy"`
+		OutsideShift    bool           `json:"outside_shift"`
+		Method          string         `json:"method"`
+		Purpose         string         `json:"purpose"`
+		ResidentId      *uint          `json:"resident_id"`
+		ScannedAt       *time.Time     `json:"scanned_at"`
+		CapturedAt      *time.Time     `json:"captured_at"`
+		LateSync        bool           `json:"late_sync"`
+		SyncReviewedAt  *time.Time     `json:"sync_reviewed_at"`
 	}
 
 	var records []SecurityRecordResponse
-	result := initializers.DB.Model(&models.SecurityRecord{}).
-		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at").
+	db := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at"+securityRecordFlagsSelect).
 		Joins("left join users on users.id = security_records.security_id").
+		Joins(securityRecordCheckpointJoin).
 		Where("security_records.security_id = ?", securityID).
-		Order("security_records.created_at DESC").
-		Scan(&records)
+		Order("security_records.created_at DESC")
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "security-records-by-user", db.Select(securityRecordExportSelect), securityRecordExportColumns)
+		return
+	}
+	result := db.Scan(&records)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get security records for this user"})
 		return
@@ -603,23 +867,42 @@ func GetSecurityRecordByUserAndByDate(c *gin.Context) {
 	endOfDay := parsedDate.Add(24 * time.Hour)
 
 	type SecurityRecordResponse struct {
-		ID           uint      `json:"id"`
-		SecurityId   uint      `json:"security_id"`
-		SecurityName string    `json:"security_name"`
-		Block        string    `json:"block"`
-		PhoneNo      string    `json:"phone_no"`
-		Longitude    string    `json:"longitude"`
-		Latitude     string    `json:"latitude"`
-		CreatedAt    time.Time `json:"created_at"`
+		ID              uint           `json:"id"`
+		SecurityId      uint           `json:"security_id"`
+		SecurityName    string         `json:"security_name"`
+		Block           string         `json:"block"`
+		PhoneNo         string         `json:"phone_no"`
+		Longitude       coordinateText `json:"longitude"`
+		Latitude        coordinateText `json:"latitude"`
+		CreatedAt       time.Time      `json:"created_at"`
+		CheckpointId    *uint          `json:"checkpoint_id"`
+		CheckpointName  string         `json:"checkpoint_name"`
+		Distance        float64        `json:"distance"`
+		Accuracy        float64        `json:"accuracy"`
+		OutsideGeofence bool           `json:"outside_geofence"`
+		LowAccuracy     bool           `json:"low_accuracy"`
+		OutsideShift    bool           `json:"outside_shift"`
+		Method          string         `json:"method"`
+		Purpose         string         `json:"purpose"`
+		ResidentId      *uint          `json:"resident_id"`
+		ScannedAt       *time.Time     `json:"scanned_at"`
+		CapturedAt      *time.Time     `json:"captured_at"`
+		LateSync        bool           `json:"late_sync"`
+		SyncReviewedAt  *time.Time     `json:"sync_reviewed_at"`
 	}
 
 	var records []SecurityRecordResponse
-	result := initializers.DB.Model(&models.SecurityRecord{}).
-		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at").
+	db := initializers.DB.Model(&models.SecurityRecord{}).
+		Select("security_records.id, security_records.security_id, users.name as security_name, security_records.block, security_records.phone_no, security_records.longitude, security_records.latitude, security_records.created_at"+securityRecordFlagsSelect).
 		Joins("left join users on users.id = security_records.security_id").
+		Joins(securityRecordCheckpointJoin).
 		Where("security_records.security_id = ? AND security_records.created_at >= ? AND security_records.created_at < ?", securityID, startOfDay, endOfDay).
-		Order("security_records.created_at DESC").
-		Scan(&records)
+		Order("security_records.created_at DESC")
+	if format := exportFormat(c); format != "" {
+		streamExport(c, format, "security-records-by-user-by-day", db.Select(securityRecordExportSelect), securityRecordExportColumns)
+		return
+	}
+	result := db.Scan(&records)
 	if result.Error != nil {
 		c.JSON(400, gin.H{"message": "Failed to get security records for this user and date"})
 		return
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"errors"
+	"mime/multipart"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxSecurityRecordPhotos is the number of photos a record can have, uploaded with it and referenced together
+const maxSecurityRecordPhotos = 5
+
+// errInvalidRecordPhotos is returned when a photo_ids entry is unknown, someone else's, already used or expired
+var errInvalidRecordPhotos = errors.New("invalid photo_ids")
+
+type securityRecordPhotoResponse struct {
+	ID        uint      `json:"id"`
+	URL       string    `json:"url"`
+	Thumbnail string    `json:"thumbnail"`
+	Caption   string    `json:"caption"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func toSecurityRecordPhotoResponse(p models.SecurityRecordPhoto) securityRecordPhotoResponse {
+	return securityRecordPhotoResponse{
+		ID:        p.ID,
+		URL:       getFullImageURL(p.Storage_Key),
+		Thumbnail: getFullImageURL(p.Thumbnail),
+		Caption:   p.Caption,
+		CreatedAt: p.CreatedAt,
+	}
+}
+
+// pendingPhotoMaxAge is how long a photo uploaded ahead can still be referenced by a record.
+// It stays well below the upload cleanup grace, which deletes the files of unreferenced photos.
+func pendingPhotoMaxAge() time.Duration {
+	if grace := initializers.AppConfig.UploadCleanupGrace / 2; grace < time.Hour {
+		return grace
+	}
+	return time.Hour
+}
+
+// securityRecordPhotos returns the photos of the records in upload order, by record id
+func securityRecordPhotos(recordIDs []uint) (map[uint][]securityRecordPhotoResponse, error) {
+	photos := map[uint][]securityRecordPhotoResponse{}
+	if len(recordIDs) == 0 {
+		return photos, nil
+	}
+	var rows []models.SecurityRecordPhoto
+	if err := initializers.DB.Where("security_record_id IN ?", recordIDs).Order("id ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		photos[*row.Security_Record_Id] = append(photos[*row.Security_Record_Id], toSecurityRecordPhotoResponse(row))
+	}
+	return photos, nil
+}
+
+// saveSecurityRecordPhotoFiles stores the photos sent with a record, the rows are created with the record.
+// On error the files saved so far are removed again.
+func saveSecurityRecordPhotoFiles(c *gin.Context, files []*multipart.FileHeader, caption string) ([]models.SecurityRecordPhoto, error) {
+	photos := make([]models.SecurityRecordPhoto, 0, len(files))
+	for _, file := range files {
+		key, thumbnailKey, err := saveUploadedImage(c, file, "security-records")
+		if err != nil {
+			deleteSecurityRecordPhotoFiles(c, photos)
+			return nil, err
+		}
+		photos = append(photos, models.SecurityRecordPhoto{Storage_Key: key, Thumbnail: thumbnailKey, Caption: caption})
+	}
+	return photos, nil
+}
+
+func deleteSecurityRecordPhotoFiles(c *gin.Context, photos []models.SecurityRecordPhoto) {
+	for _, photo := range photos {
+		deleteUploadedImage(c, photo.Storage_Key, photo.Thumbnail)
+	}
+}
+
+// attachSecurityRecordPhotos links the photos to a new record inside its transaction: the files sent with
+// the record are inserted and the photos uploaded ahead by the same guard are claimed. Every id must be
+// a pending photo of the uploader, otherwise errInvalidRecordPhotos is returned and the record rolled back.
+func attachSecurityRecordPhotos(tx *gorm.DB, recordID, uploaderID uint, uploaded []models.SecurityRecordPhoto, photoIDs []uint) error {
+	for i := range uploaded {
+		uploaded[i].Security_Record_Id = &recordID
+		uploaded[i].Uploaded_By = uploaderID
+	}
+	if len(uploaded) > 0 {
+		if err := tx.Create(&uploaded).Error; err != nil {
+			return err
+		}
+	}
+	if len(photoIDs) == 0 {
+		return nil
+	}
+	result := tx.Model(&models.SecurityRecordPhoto{}).
+		Where("id IN ? AND uploaded_by = ? AND security_record_id IS NULL AND created_at > ?", photoIDs, uploaderID, time.Now().Add(-pendingPhotoMaxAge())).
+		Update("security_record_id", recordID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(photoIDs)) {
+		return errInvalidRecordPhotos
+	}
+	return nil
+}
+
+// uniquePhotoIDs drops duplicated and zero ids so the attach count matches
+func uniquePhotoIDs(ids []uint) []uint {
+	seen := map[uint]bool{}
+	unique := ids[:0]
+	for _, id := range ids {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// UploadSecurityRecordPhoto uploads a photo (form field "file", optional "caption") ahead of the record,
+// for clients that send the record as JSON. The returned id goes into the photo_ids of the record,
+// which must be sent within the hour.
+func UploadSecurityRecordPhoto(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+	securityID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"message": "File is required"})
+		return
+	}
+	key, thumbnailKey, err := saveUploadedImage(c, file, "security-records")
+	if err != nil {
+		if message := uploadErrorMessage(err); message != "" {
+			c.JSON(400, gin.H{"message": message})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to save photo"})
+		return
+	}
+
+	photo := models.SecurityRecordPhoto{
+		Uploaded_By: securityID,
+		Storage_Key: key,
+		Thumbnail:   thumbnailKey,
+		Caption:     strings.TrimSpace(c.PostForm("caption")),
+	}
+	if err := initializers.DB.Create(&photo).Error; err != nil {
+		deleteUploadedImage(c, key, thumbnailKey)
+		c.JSON(500, gin.H{"message": "Failed to save photo"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Photo uploaded",
+		"data":    toSecurityRecordPhotoResponse(photo),
+	})
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxSyncRecords is the number of records accepted in one sync request
+	maxSyncRecords = 200
+	// syncMaxAge is how long a phone can keep a record before it is refused
+	syncMaxAge = 72 * time.Hour
+)
+
+// Result of one record of a sync request
+const (
+	syncCreated   = "created"
+	syncDuplicate = "duplicate" // already received, the record_id is the existing record
+	syncRejected  = "rejected"
+)
+
+var clientIDPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// syncRecord is one record captured by the phone while it was offline.
+// Records with a checkpoint token were scanned, all records carry coordinates.
+type syncRecord struct {
+	ClientId   string     `json:"client_id"` // UUID generated by the phone
+	CapturedAt *time.Time `json:"captured_at"`
+	Block      string     `json:"block"`
+	Token      string     `json:"token"`
+	Longitude  *float64   `json:"longitude"`
+	Latitude   *float64   `json:"latitude"`
+	Accuracy   float64    `json:"accuracy"`
+	PhotoIds   []uint     `json:"photo_ids"` // photos uploaded with POST /security-records/photos after reconnecting
+}
+
+type syncResult struct {
+	Index      int        `json:"index"`
+	ClientId   string     `json:"client_id"`
+	Status     string     `json:"status"`
+	RecordId   *uint      `json:"record_id"`
+	Message    string     `json:"message,omitempty"`
+	CapturedAt *time.Time `json:"captured_at"`
+	ReceivedAt *time.Time `json:"received_at"`
+	LateSync   bool       `json:"late_sync"` // the record waits for an admin review before it counts for the patrol schedule
+}
+
+// syncError rejects one record of a sync request without failing the others
+type syncError string
+
+func (e syncError) Error() string { return string(e) }
+
+// buildSyncRecord validates an offline record and turns it into a security record, it does not save it
+func buildSyncRecord(item syncRecord, securityUser models.User, now time.Time) (models.SecurityRecord, error) {
+	var record models.SecurityRecord
+	if item.CapturedAt == nil {
+		return record, syncError("captured_at is required")
+	}
+	capturedAt := *item.CapturedAt
+	if capturedAt.After(now.Add(checkpointScanClockSkew)) {
+		return record, syncError("captured_at is in the future")
+	}
+	if capturedAt.Before(now.Add(-syncMaxAge)) {
+		return record, syncError(fmt.Sprintf("Record is older than %d hours", int(syncMaxAge.Hours())))
+	}
+	// A photo of a printed code can be scanned anywhere, the coordinates are checked against the geofence
+	if item.Latitude == nil || item.Longitude == nil {
+		return record, syncError("latitude and longitude are required")
+	}
+	if !validCoordinates(*item.Latitude, *item.Longitude) {
+		return record, syncError("Invalid coordinates")
+	}
+	if len(uniquePhotoIDs(item.PhotoIds)) > maxSecurityRecordPhotos {
+		return record, syncError(fmt.Sprintf("A security record can have at most %d photos", maxSecurityRecordPhotos))
+	}
+
+	clientID := item.ClientId
+	receivedAt := now
+	record = models.SecurityRecord{
+		Security_Id:   securityUser.ID,
+		Block:         item.Block,
+		Phone_No:      securityUser.Phone_No,
+		Longitude:     item.Longitude,
+		Latitude:      item.Latitude,
+		Accuracy:      item.Accuracy,
+		Low_Accuracy:  item.Accuracy > initializers.AppConfig.GPSMaxAccuracy,
+		Outside_Shift: !onShift(securityUser.ID, capturedAt),
+		Client_Id:     &clientID,
+		Captured_At:   &capturedAt,
+		Received_At:   &receivedAt,
+	}
+	// The capture time is only the phone's word, a record that arrives later than an online scan may be old
+	// waits for an admin review before it counts for the patrol schedule
+	record.Late_Sync = now.Sub(capturedAt) > checkpointScanMaxAge
+
+	if item.Token == "" {
+		record.Method = "gps"
+		if err := applyGeofence(&record, *item.Latitude, *item.Longitude, item.Accuracy); err != nil {
+			return record, err
+		}
+		return record, nil
+	}
+
+	// The printed code does not change while the phone is offline, a code replaced since then is refused
+	checkpointID, nonce, ok := parseCheckpointToken(item.Token)
+	if !ok {
+		return record, syncError("Invalid checkpoint code")
+	}
+	var checkpoint models.Checkpoint
+	if err := initializers.DB.Where("id = ? AND is_active = ?", checkpointID, true).First(&checkpoint).Error; err != nil || checkpoint.Token_Nonce != nonce {
+		return record, syncError("Invalid checkpoint code")
+	}
+	// Same rescan interval as online scans, around the capture time
+	var count int64
+	err := initializers.DB.Model(&models.SecurityRecord{}).
+		Where("security_id = ? AND checkpoint_id = ? AND method = ? AND scanned_at > ? AND scanned_at < ?",
+			securityUser.ID, checkpoint.ID, "qr", capturedAt.Add(-checkpointRescanInterval), capturedAt.Add(checkpointRescanInterval)).
+		Count(&count).Error
+	if err != nil {
+		return record, err
+	}
+	if count > 0 {
+		return record, syncError("This checkpoint was already scanned at that time")
+	}
+	record.Checkpoint_Id = &checkpoint.ID
+	record.Method = "qr"
+	record.Scanned_At = &capturedAt
+	if record.Block == "" {
+		record.Block = checkpoint.Block
+	}
+	distance := distanceMeters(*item.Latitude, *item.Longitude, checkpoint.Latitude, checkpoint.Longitude)
+	record.Distance = math.Round(distance*10) / 10
+	record.Outside_Geofence = distance > checkpoint.Radius
+	return record, nil
+}
+
+// SyncSecurityRecords receives the records a guard captured while offline, in one request:
+//
+//	{"records": [{"client_id": "<uuid>", "captured_at": "2025-01-31T23:10:00+07:00", "block": "A",
+//	              "latitude": -6.2, "longitude": 106.8, "accuracy": 12, "token": "<checkpoint code>", "photo_ids": [1]}]}
+//
+// Every record is saved on its own and gets a result: created, duplicate (the client_id was received before,
+// so a retry after a lost response is safe) or rejected with a message. The request only fails as a whole
+// when the body is invalid.
+func SyncSecurityRecords(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+
+	var body struct {
+		Records []syncRecord `json:"records"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if len(body.Records) == 0 || len(body.Records) > maxSyncRecords {
+		c.JSON(400, gin.H{"message": fmt.Sprintf("Send between 1 and %d records", maxSyncRecords)})
+		return
+	}
+
+	securityID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+	var securityUser models.User
+	if err := initializers.DB.Select("id, name, phone_no").Where("id = ?", securityID).First(&securityUser).Error; err != nil {
+		c.JSON(400, gin.H{"message": "Security user not found"})
+		return
+	}
+
+	now := time.Now()
+	results := make([]syncResult, len(body.Records))
+	seen := map[string]bool{}
+	summary := map[string]int{syncCreated: 0, syncDuplicate: 0, syncRejected: 0}
+	for i, item := range body.Records {
+		item.ClientId = strings.ToLower(strings.TrimSpace(item.ClientId))
+		result := syncResult{Index: i, ClientId: item.ClientId, CapturedAt: item.CapturedAt}
+		reject := func(message string) {
+			result.Status = syncRejected
+			result.Message = message
+		}
+
+		switch {
+		case !clientIDPattern.MatchString(item.ClientId):
+			reject("client_id must be a UUID")
+		case seen[item.ClientId]:
+			reject("client_id is repeated in this request")
+		default:
+			seen[item.ClientId] = true
+			var existing models.SecurityRecord
+			err := initializers.DB.Unscoped().Select("id, security_id, created_at, captured_at, late_sync").Where("client_id = ?", item.ClientId).First(&existing).Error
+			if err == nil {
+				if existing.Security_Id != securityID {
+					reject("client_id is already used")
+					break
+				}
+				result.Status = syncDuplicate
+				result.RecordId = &existing.ID
+				result.CapturedAt = existing.Captured_At
+				result.ReceivedAt = &existing.CreatedAt
+				result.LateSync = existing.Late_Sync
+				break
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				reject("Failed to save security record")
+				break
+			}
+
+			record, err := buildSyncRecord(item, securityUser, now)
+			var invalid syncError
+			if errors.As(err, &invalid) {
+				reject(invalid.Error())
+				break
+			}
+			if err != nil {
+				reject("Failed to match checkpoint")
+				break
+			}
+			err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Create(&record).Error; err != nil {
+					return err
+				}
+				return attachSecurityRecordPhotos(tx, record.ID, securityID, nil, uniquePhotoIDs(item.PhotoIds))
+			})
+			if errors.Is(err, errInvalidRecordPhotos) {
+				reject("Unknown, used or expired photo_ids, upload the photos again")
+				break
+			}
+			if err != nil {
+				reject("Failed to save security record")
+				break
+			}
+			result.Status = syncCreated
+			result.RecordId = &record.ID
+			result.ReceivedAt = &record.CreatedAt
+			result.LateSync = record.Late_Sync
+			event := gin.H{
+				"id":               record.ID,
+				"security_id":      securityID,
+				"security_name":    securityUser.Name,
+				"block":            record.Block,
+				"longitude":        formatCoordinate(record.Longitude),
+				"latitude":         formatCoordinate(record.Latitude),
+				"checkpoint_id":    record.Checkpoint_Id,
+				"distance":         record.Distance,
+				"accuracy":         record.Accuracy,
+				"outside_geofence": record.Outside_Geofence,
+				"low_accuracy":     record.Low_Accuracy,
+				"outside_shift":    record.Outside_Shift,
+				"method":           record.Method,
+				"captured_at":      record.Captured_At,
+				"late_sync":        record.Late_Sync,
+				"created_at":       record.CreatedAt,
+			}
+			publishSecurityRecord(record, event, event)
+		}
+		summary[result.Status]++
+		results[i] = result
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Security records synced",
+		"summary": summary,
+		"data":    results,
+	})
+}
+
+// ReviewSyncedSecurityRecord lets an admin accept a record that was synced late, after checking it
+// (for example against the photos or the shift), so it counts for the patrol schedule.
+func ReviewSyncedSecurityRecord(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var record models.SecurityRecord
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&record).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Security record not found"})
+		return
+	}
+	if !record.Late_Sync {
+		c.JSON(400, gin.H{"message": "Security record was not synced late"})
+		return
+	}
+
+	uid, _ := currentUserID(c)
+	now := time.Now()
+	result := initializers.DB.Model(&models.SecurityRecord{}).
+		Where("id = ? AND sync_reviewed_at IS NULL", record.ID).
+		Updates(map[string]interface{}{"sync_reviewed_by": uid, "sync_reviewed_at": now})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"message": "Failed to review security record"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(409, gin.H{"message": "Security record was already reviewed"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Security record reviewed",
+		"data": gin.H{
+			"id":               record.ID,
+			"captured_at":      record.Captured_At,
+			"received_at":      record.Received_At,
+			"sync_reviewed_by": uid,
+			"sync_reviewed_at": now,
+		},
+	})
+}
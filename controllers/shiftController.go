@@ -0,0 +1,571 @@
+package controllers
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// clockInEarly is how long before the start of a shift a guard can clock in
+	clockInEarly = 30 * time.Minute
+	// clockInLateAfter marks an attendance as late
+	clockInLateAfter = 15 * time.Minute
+	// maxShiftLength guards against typos in the end time
+	maxShiftLength = 24 * time.Hour
+)
+
+// currentUserID returns the id of the authenticated user
+func currentUserID(c *gin.Context) (uint, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		return 0, false
+	}
+	uid, ok := userID.(uint)
+	return uid, ok
+}
+
+// onShift checks if the guard is assigned to a shift running at the given time
+func onShift(securityID uint, t time.Time) bool {
+	var count int64
+	initializers.DB.Model(&models.ShiftAssignment{}).
+		Joins("join shifts on shifts.id = shift_assignments.shift_id AND shifts.deleted_at IS NULL").
+		Where("shift_assignments.security_id = ? AND shifts.start_at <= ? AND shifts.end_at > ?", securityID, t, t).
+		Count(&count)
+	return count > 0
+}
+
+type shiftAssignmentResponse struct {
+	SecurityId   uint       `json:"security_id"`
+	SecurityName string     `json:"security_name"`
+	ClockInAt    *time.Time `json:"clock_in_at"`
+	ClockOutAt   *time.Time `json:"clock_out_at"`
+}
+
+type shiftResponse struct {
+	ID          uint                      `json:"id"`
+	Name        string                    `json:"name"`
+	StartAt     time.Time                 `json:"start_at"`
+	EndAt       time.Time                 `json:"end_at"`
+	Notes       string                    `json:"notes"`
+	Assignments []shiftAssignmentResponse `json:"assignments"`
+}
+
+func toShiftResponse(shift models.Shift) shiftResponse {
+	assignments := make([]shiftAssignmentResponse, len(shift.Assignments))
+	for i, a := range shift.Assignments {
+		assignments[i] = shiftAssignmentResponse{
+			SecurityId:   a.Security_Id,
+			SecurityName: a.Security.Name,
+			ClockInAt:    a.Clock_In_At,
+			ClockOutAt:   a.Clock_Out_At,
+		}
+	}
+	return shiftResponse{
+		ID:          shift.ID,
+		Name:        shift.Name,
+		StartAt:     shift.Start_At,
+		EndAt:       shift.End_At,
+		Notes:       shift.Notes,
+		Assignments: assignments,
+	}
+}
+
+func preloadShiftAssignments(db *gorm.DB) *gorm.DB {
+	return db.Preload("Assignments").Preload("Assignments.Security", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id, name")
+	})
+}
+
+// checkSecurityUsers returns false when one of the ids is not a security user, the ids must be unique
+func checkSecurityUsers(ids []uint) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	var count int64
+	initializers.DB.Model(&models.User{}).Where("id IN ? AND role_id = ?", ids, 3).Count(&count)
+	return count == int64(len(ids))
+}
+
+// GetShifts lists the shifts overlapping ?from= and ?to= (YYYY-MM-DD, default the current month).
+// Security users only get the shifts they are assigned to.
+func GetShifts(c *gin.Context) {
+	admin := isAdmin(c)
+	if !admin && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	now := time.Now().In(jakartaLocation)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, jakartaLocation)
+	to := from.AddDate(0, 1, 0)
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromStr, jakartaLocation)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid from date. Use YYYY-MM-DD."})
+			return
+		}
+		from = parsed
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toStr, jakartaLocation)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid to date. Use YYYY-MM-DD."})
+			return
+		}
+		to = parsed.AddDate(0, 0, 1)
+	}
+
+	db := preloadShiftAssignments(initializers.DB).
+		Where("shifts.start_at < ? AND shifts.end_at > ?", to, from).
+		Order("shifts.start_at ASC")
+	if !admin {
+		uid, _ := currentUserID(c)
+		db = db.Where("EXISTS (SELECT 1 FROM shift_assignments WHERE shift_assignments.shift_id = shifts.id AND shift_assignments.security_id = ? AND shift_assignments.deleted_at IS NULL)", uid)
+	}
+
+	var shifts []models.Shift
+	if err := db.Find(&shifts).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get shifts"})
+		return
+	}
+
+	response := make([]shiftResponse, len(shifts))
+	for i, shift := range shifts {
+		response[i] = toShiftResponse(shift)
+	}
+	c.JSON(200, gin.H{"data": response})
+}
+
+// CreateShift adds a shift and assigns the guards in security_ids
+func CreateShift(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var body struct {
+		Name        string    `json:"name"`
+		StartAt     time.Time `json:"start_at"`
+		EndAt       time.Time `json:"end_at"`
+		Notes       string    `json:"notes"`
+		SecurityIds []uint    `json:"security_ids"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if !body.EndAt.After(body.StartAt) || body.EndAt.Sub(body.StartAt) > maxShiftLength {
+		c.JSON(400, gin.H{"message": "end_at must be after start_at and a shift can last at most 24 hours"})
+		return
+	}
+	seen := map[uint]bool{}
+	var securityIDs []uint
+	for _, id := range body.SecurityIds {
+		if !seen[id] {
+			seen[id] = true
+			securityIDs = append(securityIDs, id)
+		}
+	}
+	if !checkSecurityUsers(securityIDs) {
+		c.JSON(400, gin.H{"message": "security_ids must be security users"})
+		return
+	}
+
+	shift := models.Shift{Name: body.Name, Start_At: body.StartAt, End_At: body.EndAt, Notes: body.Notes}
+	for _, id := range securityIDs {
+		shift.Assignments = append(shift.Assignments, models.ShiftAssignment{Security_Id: id})
+	}
+	if err := initializers.DB.Create(&shift).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create shift"})
+		return
+	}
+
+	shift.Assignments = nil
+	preloadShiftAssignments(initializers.DB).First(&shift, shift.ID)
+	c.JSON(200, gin.H{
+		"message": "Shift created successfully",
+		"data":    toShiftResponse(shift),
+	})
+}
+
+// UpdateShift changes the name, times or notes of a shift
+func UpdateShift(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var shift models.Shift
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&shift).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Shift not found"})
+		return
+	}
+
+	var body struct {
+		Name    *string    `json:"name"`
+		StartAt *time.Time `json:"start_at"`
+		EndAt   *time.Time `json:"end_at"`
+		Notes   *string    `json:"notes"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.Name != nil {
+		shift.Name = *body.Name
+	}
+	if body.StartAt != nil {
+		shift.Start_At = *body.StartAt
+	}
+	if body.EndAt != nil {
+		shift.End_At = *body.EndAt
+	}
+	if body.Notes != nil {
+		shift.Notes = *body.Notes
+	}
+	if !shift.End_At.After(shift.Start_At) || shift.End_At.Sub(shift.Start_At) > maxShiftLength {
+		c.JSON(400, gin.H{"message": "end_at must be after start_at and a shift can last at most 24 hours"})
+		return
+	}
+
+	if err := initializers.DB.Save(&shift).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update shift"})
+		return
+	}
+
+	preloadShiftAssignments(initializers.DB).First(&shift, shift.ID)
+	c.JSON(200, gin.H{
+		"message": "Shift updated",
+		"data":    toShiftResponse(shift),
+	})
+}
+
+// DeleteShift removes a shift and its assignments
+func DeleteShift(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var shift models.Shift
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&shift).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Shift not found"})
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("shift_id = ?", shift.ID).Delete(&models.ShiftAssignment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&shift).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to delete shift"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Shift deleted"})
+}
+
+// AssignShift puts a guard on a shift
+func AssignShift(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	var shift models.Shift
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&shift).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Shift not found"})
+		return
+	}
+
+	var body struct {
+		SecurityId uint `json:"security_id"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if !checkSecurityUsers([]uint{body.SecurityId}) {
+		c.JSON(400, gin.H{"message": "security_id must be a security user"})
+		return
+	}
+
+	// Unscoped so a guard removed earlier can be assigned again without breaking the unique index
+	var assignment models.ShiftAssignment
+	err := initializers.DB.Unscoped().Where("shift_id = ? AND security_id = ?", shift.ID, body.SecurityId).First(&assignment).Error
+	if err == nil && !assignment.DeletedAt.Valid {
+		c.JSON(409, gin.H{"message": "Guard is already assigned to this shift"})
+		return
+	}
+	if err == nil {
+		err = initializers.DB.Unscoped().Model(&assignment).Update("deleted_at", nil).Error
+	} else {
+		err = initializers.DB.Create(&models.ShiftAssignment{Shift_Id: shift.ID, Security_Id: body.SecurityId}).Error
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to assign guard"})
+		return
+	}
+
+	preloadShiftAssignments(initializers.DB).First(&shift, shift.ID)
+	c.JSON(200, gin.H{
+		"message": "Guard assigned",
+		"data":    toShiftResponse(shift),
+	})
+}
+
+// UnassignShift removes a guard from a shift
+func UnassignShift(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	result := initializers.DB.Where("shift_id = ? AND security_id = ?", c.Param("id"), c.Param("securityId")).Delete(&models.ShiftAssignment{})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"message": "Failed to remove guard"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"message": "Guard is not assigned to this shift"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Guard removed from shift"})
+}
+
+// clockBody is the location sent when clocking in or out
+type clockBody struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+// ownAssignment loads the assignment of the authenticated guard to the shift in the URL
+func ownAssignment(c *gin.Context) (*models.ShiftAssignment, *models.Shift, bool) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return nil, nil, false
+	}
+	uid, ok := currentUserID(c)
+	if !ok {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return nil, nil, false
+	}
+
+	var shift models.Shift
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&shift).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Shift not found"})
+		return nil, nil, false
+	}
+	var assignment models.ShiftAssignment
+	if err := initializers.DB.Where("shift_id = ? AND security_id = ?", shift.ID, uid).First(&assignment).Error; err != nil {
+		c.JSON(403, gin.H{"message": "You are not assigned to this shift"})
+		return nil, nil, false
+	}
+	return &assignment, &shift, true
+}
+
+func bindClockBody(c *gin.Context) (clockBody, bool) {
+	var body clockBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return body, false
+	}
+	if body.Latitude == nil || body.Longitude == nil || !validCoordinates(*body.Latitude, *body.Longitude) {
+		c.JSON(400, gin.H{"message": "latitude and longitude are required"})
+		return body, false
+	}
+	return body, true
+}
+
+// ClockIn records the start of duty of the authenticated guard, from clockInEarly before the shift until its end
+func ClockIn(c *gin.Context) {
+	assignment, shift, ok := ownAssignment(c)
+	if !ok {
+		return
+	}
+	body, ok := bindClockBody(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if assignment.Clock_In_At != nil {
+		c.JSON(409, gin.H{"message": "Already clocked in"})
+		return
+	}
+	if now.Before(shift.Start_At.Add(-clockInEarly)) || !now.Before(shift.End_At) {
+		c.JSON(400, gin.H{"message": "Clock in is only possible during the shift"})
+		return
+	}
+
+	err := initializers.DB.Model(assignment).Updates(map[string]interface{}{
+		"clock_in_at":        now,
+		"clock_in_latitude":  *body.Latitude,
+		"clock_in_longitude": *body.Longitude,
+	}).Error
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to clock in"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Clocked in",
+		"data":    gin.H{"shift_id": shift.ID, "clock_in_at": now, "late": now.After(shift.Start_At.Add(clockInLateAfter))},
+	})
+}
+
+// ClockOut records the end of duty of the authenticated guard
+func ClockOut(c *gin.Context) {
+	assignment, shift, ok := ownAssignment(c)
+	if !ok {
+		return
+	}
+	body, ok := bindClockBody(c)
+	if !ok {
+		return
+	}
+
+	if assignment.Clock_In_At == nil {
+		c.JSON(400, gin.H{"message": "Clock in first"})
+		return
+	}
+	if assignment.Clock_Out_At != nil {
+		c.JSON(409, gin.H{"message": "Already clocked out"})
+		return
+	}
+
+	now := time.Now()
+	err := initializers.DB.Model(assignment).Updates(map[string]interface{}{
+		"clock_out_at":        now,
+		"clock_out_latitude":  *body.Latitude,
+		"clock_out_longitude": *body.Longitude,
+	}).Error
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to clock out"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Clocked out",
+		"data":    gin.H{"shift_id": shift.ID, "clock_in_at": assignment.Clock_In_At, "clock_out_at": now, "early": now.Before(shift.End_At)},
+	})
+}
+
+// GetShiftAttendance summarizes the attendance of every guard for ?month=&year= (default the current month)
+func GetShiftAttendance(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	now := time.Now().In(jakartaLocation)
+	month, year := int(now.Month()), now.Year()
+	if monthStr, yearStr := c.Query("month"), c.Query("year"); monthStr != "" || yearStr != "" {
+		m, err1 := strconv.Atoi(monthStr)
+		y, err2 := strconv.Atoi(yearStr)
+		if err1 != nil || err2 != nil || m < 1 || m > 12 || y < 1 {
+			c.JSON(400, gin.H{"message": "Invalid month or year"})
+			return
+		}
+		month, year = m, y
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, jakartaLocation)
+	end := start.AddDate(0, 1, 0)
+
+	type attendanceRow struct {
+		Security_Id  uint
+		Name         string
+		Start_At     time.Time
+		End_At       time.Time
+		Clock_In_At  *time.Time
+		Clock_Out_At *time.Time
+	}
+	var rows []attendanceRow
+	err := initializers.DB.Model(&models.ShiftAssignment{}).
+		Select("shift_assignments.security_id, users.name, shifts.start_at, shifts.end_at, shift_assignments.clock_in_at, shift_assignments.clock_out_at").
+		Joins("join shifts on shifts.id = shift_assignments.shift_id AND shifts.deleted_at IS NULL").
+		Joins("left join users on users.id = shift_assignments.security_id").
+		Where("shifts.start_at >= ? AND shifts.start_at < ?", start, end).
+		Order("users.name ASC, shifts.start_at ASC").
+		Scan(&rows).Error
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get attendance"})
+		return
+	}
+
+	type Attendance struct {
+		SecurityId          uint    `json:"security_id"`
+		SecurityName        string  `json:"security_name"`
+		Shifts              int     `json:"shifts"`
+		Attended            int     `json:"attended"`
+		Late                int     `json:"late"`
+		Absent              int     `json:"absent"`
+		Upcoming            int     `json:"upcoming"`
+		HoursScheduled      float64 `json:"hours_scheduled"`
+		HoursWorked         float64 `json:"hours_worked"`
+		RecordsOutsideShift int64   `json:"records_outside_shift"`
+	}
+	var attendance []*Attendance
+	byGuard := map[uint]*Attendance{}
+	for _, row := range rows {
+		a, ok := byGuard[row.Security_Id]
+		if !ok {
+			a = &Attendance{SecurityId: row.Security_Id, SecurityName: row.Name}
+			byGuard[row.Security_Id] = a
+			attendance = append(attendance, a)
+		}
+		a.Shifts++
+		a.HoursScheduled += row.End_At.Sub(row.Start_At).Hours()
+		switch {
+		case row.Clock_In_At != nil:
+			a.Attended++
+			if row.Clock_In_At.After(row.Start_At.Add(clockInLateAfter)) {
+				a.Late++
+			}
+			// Only the time inside the shift is paid, a missing clock out counts until the end of the shift
+			// or, while the shift is running, until now
+			from, to := *row.Clock_In_At, row.End_At
+			if row.Clock_Out_At != nil && row.Clock_Out_At.Before(to) {
+				to = *row.Clock_Out_At
+			} else if row.Clock_Out_At == nil && now.Before(to) {
+				to = now
+			}
+			if from.Before(row.Start_At) {
+				from = row.Start_At
+			}
+			if to.After(from) {
+				a.HoursWorked += to.Sub(from).Hours()
+			}
+		case row.End_At.Before(now):
+			a.Absent++
+		default:
+			a.Upcoming++
+		}
+	}
+
+	for _, a := range attendance {
+		a.HoursScheduled = math.Round(a.HoursScheduled*100) / 100
+		a.HoursWorked = math.Round(a.HoursWorked*100) / 100
+		initializers.DB.Model(&models.SecurityRecord{}).
+			Where("security_id = ? AND outside_shift = ? AND created_at >= ? AND created_at < ?", a.SecurityId, true, start, end).
+			Count(&a.RecordsOutsideShift)
+	}
+	if attendance == nil {
+		attendance = []*Attendance{}
+	}
+
+	c.JSON(200, gin.H{
+		"month": month,
+		"year":  year,
+		"data":  attendance,
+	})
+}
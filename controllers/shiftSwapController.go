@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type shiftSwapResponse struct {
+	ID            uint      `json:"id"`
+	ShiftId       uint      `json:"shift_id"`
+	ShiftName     string    `json:"shift_name"`
+	ShiftStartAt  time.Time `json:"shift_start_at"`
+	ShiftEndAt    time.Time `json:"shift_end_at"`
+	RequesterId   uint      `json:"requester_id"`
+	RequesterName string    `json:"requester_name"`
+	TargetId      uint      `json:"target_id"`
+	TargetName    string    `json:"target_name"`
+	Reason        string    `json:"reason"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// shiftSwapSelect selects the columns of shiftSwapResponse
+const shiftSwapSelect = "shift_swap_requests.id, shifts.id as shift_id, shifts.name as shift_name, shifts.start_at as shift_start_at, shifts.end_at as shift_end_at, " +
+	"shift_swap_requests.requester_id, requesters.name as requester_name, shift_swap_requests.target_id, targets.name as target_name, " +
+	"shift_swap_requests.reason, shift_swap_requests.status, shift_swap_requests.created_at"
+
+func shiftSwapQuery() *gorm.DB {
+	return initializers.DB.Model(&models.ShiftSwapRequest{}).
+		Select(shiftSwapSelect).
+		Joins("join shift_assignments on shift_assignments.id = shift_swap_requests.assignment_id").
+		Joins("join shifts on shifts.id = shift_assignments.shift_id").
+		Joins("left join users requesters on requesters.id = shift_swap_requests.requester_id").
+		Joins("left join users targets on targets.id = shift_swap_requests.target_id")
+}
+
+func getShiftSwapResponse(id uint) shiftSwapResponse {
+	var response shiftSwapResponse
+	shiftSwapQuery().Where("shift_swap_requests.id = ?", id).Scan(&response)
+	return response
+}
+
+// GetShiftSwaps lists swap requests, ?status= filters by status.
+// Security users only see the requests they made or received.
+func GetShiftSwaps(c *gin.Context) {
+	admin := isAdmin(c)
+	if !admin && !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+
+	db := shiftSwapQuery().Order("shift_swap_requests.created_at DESC")
+	if status := c.Query("status"); status != "" {
+		db = db.Where("shift_swap_requests.status = ?", status)
+	}
+	if !admin {
+		uid, _ := currentUserID(c)
+		db = db.Where("shift_swap_requests.requester_id = ? OR shift_swap_requests.target_id = ?", uid, uid)
+	}
+
+	var swaps []shiftSwapResponse
+	if err := db.Scan(&swaps).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get swap requests"})
+		return
+	}
+	if swaps == nil {
+		swaps = []shiftSwapResponse{}
+	}
+	c.JSON(200, gin.H{"data": swaps})
+}
+
+// CreateShiftSwap asks another guard to take over one of the shifts of the authenticated guard
+func CreateShiftSwap(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+	uid, ok := currentUserID(c)
+	if !ok {
+		c.JSON(400, gin.H{"message": "User not authenticated"})
+		return
+	}
+
+	var body struct {
+		ShiftId  uint   `json:"shift_id"`
+		TargetId uint   `json:"target_id"`
+		Reason   string `json:"reason"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if body.TargetId == uid || !checkSecurityUsers([]uint{body.TargetId}) {
+		c.JSON(400, gin.H{"message": "target_id must be another security user"})
+		return
+	}
+
+	var assignment models.ShiftAssignment
+	if err := initializers.DB.Preload("Shift").Where("shift_id = ? AND security_id = ?", body.ShiftId, uid).First(&assignment).Error; err != nil {
+		c.JSON(400, gin.H{"message": "You are not assigned to this shift"})
+		return
+	}
+	if assignment.Clock_In_At != nil || !time.Now().Before(assignment.Shift.Start_At) {
+		c.JSON(400, gin.H{"message": "A shift can only be swapped before it starts"})
+		return
+	}
+
+	var count int64
+	initializers.DB.Model(&models.ShiftAssignment{}).Where("shift_id = ? AND security_id = ?", body.ShiftId, body.TargetId).Count(&count)
+	if count > 0 {
+		c.JSON(400, gin.H{"message": "This guard is already on the shift"})
+		return
+	}
+	initializers.DB.Model(&models.ShiftSwapRequest{}).Where("assignment_id = ? AND status IN ?", assignment.ID, []string{"Pending", "Accepted"}).Count(&count)
+	if count > 0 {
+		c.JSON(409, gin.H{"message": "There is already an open swap request for this shift"})
+		return
+	}
+
+	swap := models.ShiftSwapRequest{
+		Assignment_Id: assignment.ID,
+		Requester_Id:  uid,
+		Target_Id:     body.TargetId,
+		Reason:        body.Reason,
+		Status:        "Pending",
+	}
+	if err := initializers.DB.Create(&swap).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create swap request"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Swap request created",
+		"data":    getShiftSwapResponse(swap.ID),
+	})
+}
+
+// shiftSwapTransitions lists the status changes allowed by the action in the URL and who may make them
+var shiftSwapTransitions = map[string]struct {
+	from string
+	to   string
+	by   string // "requester", "target" or "admin"
+}{
+	"accept":  {"Pending", "Accepted", "target"},
+	"decline": {"Pending", "Declined", "target"},
+	"cancel":  {"Pending", "Cancelled", "requester"},
+	"approve": {"Accepted", "Approved", "admin"},
+	"reject":  {"Accepted", "Rejected", "admin"},
+}
+
+var (
+	errShiftSwapChanged = errors.New("swap request status changed")
+	errGuardOnShift     = errors.New("guard is already on the shift")
+)
+
+// moveShiftAssignment hands an assignment over to another guard. When that guard was removed from the
+// shift earlier, the unique (shift_id, security_id) index still holds the soft deleted row, so it is
+// restored and the original assignment removed instead of reassigned.
+func moveShiftAssignment(tx *gorm.DB, assignment models.ShiftAssignment, securityID uint) error {
+	var existing models.ShiftAssignment
+	err := tx.Unscoped().Where("shift_id = ? AND security_id = ?", assignment.Shift_Id, securityID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Model(&models.ShiftAssignment{}).Where("id = ?", assignment.ID).Update("security_id", securityID).Error
+	}
+	if err != nil {
+		return err
+	}
+	if !existing.DeletedAt.Valid {
+		return errGuardOnShift
+	}
+	err = tx.Unscoped().Model(&existing).Updates(map[string]interface{}{
+		"deleted_at":          nil,
+		"clock_in_at":         nil,
+		"clock_in_latitude":   nil,
+		"clock_in_longitude":  nil,
+		"clock_out_at":        nil,
+		"clock_out_latitude":  nil,
+		"clock_out_longitude": nil,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Delete(&models.ShiftAssignment{}, assignment.ID).Error
+}
+
+// UpdateShiftSwap moves a swap request forward: the target guard accepts or declines, the requester may cancel
+// while it is pending, and an admin approves (the shift moves to the target guard) or rejects it.
+func UpdateShiftSwap(c *gin.Context) {
+	transition, ok := shiftSwapTransitions[c.Param("action")]
+	if !ok {
+		c.JSON(404, gin.H{"message": "Unknown action"})
+		return
+	}
+
+	var swap models.ShiftSwapRequest
+	if err := initializers.DB.Preload("Assignment.Shift").Where("id = ?", c.Param("id")).First(&swap).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Swap request not found"})
+		return
+	}
+
+	uid, _ := currentUserID(c)
+	allowed := false
+	switch transition.by {
+	case "admin":
+		allowed = isAdmin(c)
+	case "requester":
+		allowed = swap.Requester_Id == uid
+	case "target":
+		allowed = swap.Target_Id == uid
+	}
+	if !allowed {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+	if swap.Status != transition.from {
+		c.JSON(409, gin.H{"message": "Swap request is " + swap.Status})
+		return
+	}
+	if transition.to == "Approved" && !time.Now().Before(swap.Assignment.Shift.Start_At) {
+		c.JSON(400, gin.H{"message": "The shift has already started"})
+		return
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"status": transition.to}
+		if transition.by == "admin" {
+			updates["decided_by"] = uid
+		}
+		// Only one of two concurrent requests moves the swap out of its current status
+		result := tx.Model(&models.ShiftSwapRequest{}).Where("id = ? AND status = ?", swap.ID, transition.from).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errShiftSwapChanged
+		}
+		if transition.to != "Approved" {
+			return nil
+		}
+		return moveShiftAssignment(tx, swap.Assignment, swap.Target_Id)
+	})
+	if errors.Is(err, errShiftSwapChanged) {
+		c.JSON(409, gin.H{"message": "Swap request was already updated"})
+		return
+	}
+	if errors.Is(err, errGuardOnShift) {
+		c.JSON(409, gin.H{"message": "This guard is already on the shift"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to update swap request"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": "Swap request " + transition.to,
+		"data":    getShiftSwapResponse(swap.ID),
+	})
+}
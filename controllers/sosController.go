@@ -0,0 +1,517 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/dontkeep/simaling-backend/notify"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sosCategories lists the accepted SOS categories
+var sosCategories = map[string]bool{
+	"medical": true,
+	"theft":   true,
+	"fire":    true,
+	"other":   true,
+}
+
+// sosOrder lists SOS alerts newest first, the id keeps cursors unique
+var sosOrder = sortOrder{
+	{field: sortField{Key: "created_at", Column: "sos_alerts.created_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "sos_alerts.id"}, desc: true},
+}
+
+type sosResponse struct {
+	ID              uint       `json:"id"`
+	ResidentId      uint       `json:"resident_id"`
+	ResidentName    string     `json:"resident_name"`
+	ResidentPhone   string     `json:"resident_phone"`
+	Category        string     `json:"category"`
+	Message         string     `json:"message"`
+	Block           string     `json:"block"`
+	Latitude        float64    `json:"latitude"`
+	Longitude       float64    `json:"longitude"`
+	Accuracy        float64    `json:"accuracy"`
+	Status          string     `json:"status"`
+	IsDrill         bool       `json:"is_drill"`
+	AcknowledgedAt  *time.Time `json:"acknowledged_at"`
+	ResponseSeconds *int       `json:"response_seconds"`
+	ResolvedAt      *time.Time `json:"resolved_at"`
+	ResolvedBy      *uint      `json:"resolved_by"`
+	Resolution      string     `json:"resolution"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type sosAcknowledgementResponse struct {
+	UserId    uint      `json:"user_id"`
+	Name      string    `json:"name"`
+	Latitude  *float64  `json:"latitude"`
+	Longitude *float64  `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type sosDetailResponse struct {
+	sosResponse
+	Acknowledgements []sosAcknowledgementResponse `json:"acknowledgements"`
+}
+
+func sosQuery() *gorm.DB {
+	return initializers.DB.Model(&models.SOSAlert{}).
+		Select("sos_alerts.id, sos_alerts.resident_id, users.name as resident_name, users.phone_no as resident_phone, sos_alerts.category, " +
+			"sos_alerts.message, sos_alerts.block, sos_alerts.latitude, sos_alerts.longitude, sos_alerts.accuracy, sos_alerts.status, " +
+			"sos_alerts.is_drill, sos_alerts.acknowledged_at, sos_alerts.response_seconds, sos_alerts.resolved_at, sos_alerts.resolved_by, " +
+			"sos_alerts.resolution, sos_alerts.created_at").
+		Joins("left join users on users.id = sos_alerts.resident_id")
+}
+
+func getSOSDetail(id uint) (sosDetailResponse, error) {
+	var detail sosDetailResponse
+	if err := sosQuery().Where("sos_alerts.id = ?", id).Scan(&detail.sosResponse).Error; err != nil {
+		return detail, err
+	}
+	err := initializers.DB.Model(&models.SOSAcknowledgement{}).
+		Select("sos_acknowledgements.user_id, users.name, sos_acknowledgements.latitude, sos_acknowledgements.longitude, sos_acknowledgements.created_at").
+		Joins("left join users on users.id = sos_acknowledgements.user_id").
+		Where("sos_acknowledgements.sos_id = ?", id).
+		Order("sos_acknowledgements.created_at ASC").
+		Scan(&detail.Acknowledgements).Error
+	if detail.Acknowledgements == nil {
+		detail.Acknowledgements = []sosAcknowledgementResponse{}
+	}
+	return detail, err
+}
+
+// sosResponders are the guards on duty and the admins. Without a roster for the current time
+// every guard is alerted, an SOS must never reach nobody.
+func sosResponders() []notify.Recipient {
+	onDuty, _ := onDutyAssignments(time.Now())
+	if len(onDuty) > 0 {
+		return patrolAlertRecipients(onDuty)
+	}
+	var guards []models.User
+	initializers.DB.Select("id, name, phone_no").Where("role_id = ?", 3).Find(&guards)
+	return append(userRecipients(guards), adminRecipients()...)
+}
+
+// dispatchSOS sends an SOS notification without holding up the request. Drills are labelled in the title.
+func dispatchSOS(sos models.SOSAlert, event, title, body string, recipients []notify.Recipient) {
+	if len(recipients) == 0 {
+		return
+	}
+	if sos.Is_Drill {
+		title = "[DRILL] " + title
+	}
+	msg := notify.Message{
+		Event:      event,
+		Title:      title,
+		Body:       body,
+		Recipients: recipients,
+		Data: map[string]interface{}{
+			"sos_id":    sos.ID,
+			"category":  sos.Category,
+			"status":    sos.Status,
+			"is_drill":  sos.Is_Drill,
+			"block":     sos.Block,
+			"latitude":  sos.Latitude,
+			"longitude": sos.Longitude,
+		},
+	}
+	go initializers.Notifier.Send(context.Background(), msg)
+}
+
+// findSOS loads the SOS in the URL, residents only find their own
+func findSOS(c *gin.Context, user models.User) (*models.SOSAlert, bool) {
+	db := initializers.DB.Where("id = ?", c.Param("id"))
+	if user.Role_Id == 2 {
+		db = db.Where("resident_id = ?", user.ID)
+	}
+	var sos models.SOSAlert
+	if err := db.First(&sos).Error; err != nil {
+		c.JSON(404, gin.H{"message": "SOS not found"})
+		return nil, false
+	}
+	return &sos, true
+}
+
+// TriggerSOS raises an SOS for the authenticated resident and alerts the responders right away:
+//
+//	{"category": "medical", "latitude": -6.2, "longitude": 106.8, "accuracy": 12, "message": "...", "drill": false}
+//
+// A resident with an SOS that is still open gets that one back instead of a new one.
+// Drills test the dispatch and can only be triggered by admins and security, who cannot raise a real SOS.
+func TriggerSOS(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Category  string   `json:"category"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+		Accuracy  float64  `json:"accuracy"`
+		Message   string   `json:"message"`
+		Block     string   `json:"block"`
+		Drill     bool     `json:"drill"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if !sosCategories[body.Category] {
+		c.JSON(400, gin.H{"message": "Invalid category, use medical, theft, fire or other"})
+		return
+	}
+	if body.Latitude == nil || body.Longitude == nil || !validCoordinates(*body.Latitude, *body.Longitude) {
+		c.JSON(400, gin.H{"message": "latitude and longitude are required"})
+		return
+	}
+	if body.Drill && !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Only admins and security can trigger drills"})
+		return
+	}
+	if staff && !body.Drill {
+		c.JSON(400, gin.H{"message": "Admins and security can only trigger drills"})
+		return
+	}
+
+	block := body.Block
+	if block == "" {
+		block = user.Block
+	}
+	sos := models.SOSAlert{
+		Resident_Id: user.ID,
+		Category:    body.Category,
+		Message:     body.Message,
+		Block:       block,
+		Latitude:    *body.Latitude,
+		Longitude:   *body.Longitude,
+		Accuracy:    body.Accuracy,
+		Status:      "active",
+		Is_Drill:    body.Drill,
+	}
+	// The user row is locked while checking for an open SOS, so a double tap cannot create two
+	var open models.SOSAlert
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var locked models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, user.ID).Error; err != nil {
+			return err
+		}
+		err := tx.Where("resident_id = ? AND is_drill = ? AND status IN ?", user.ID, body.Drill, []string{"active", "acknowledged"}).First(&open).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err // nil when an SOS is already open
+		}
+		return tx.Create(&sos).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to send SOS"})
+		return
+	}
+	if open.ID != 0 {
+		detail, _ := getSOSDetail(open.ID)
+		c.JSON(200, gin.H{
+			"message": "An SOS is already active",
+			"data":    detail,
+		})
+		return
+	}
+
+	text := fmt.Sprintf("%s (%s) needs help: %s, block %s", user.Name, user.Phone_No, sos.Category, sos.Block)
+	if sos.Message != "" {
+		text += ". " + sos.Message
+	}
+	dispatchSOS(sos, "sos", "SOS: "+sos.Category, text, sosResponders())
+
+	detail, _ := getSOSDetail(sos.ID)
+	publishEvent("sos.raised", detail, staffAudience(sos.Resident_Id))
+	c.JSON(200, gin.H{
+		"message": "SOS sent",
+		"data":    detail,
+	})
+}
+
+// GetSOSAlerts lists SOS alerts, ?status= and ?drill=true|false filter the list.
+// Residents only get their own.
+func GetSOSAlerts(c *gin.Context) {
+	user, _, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+
+	pagination, err := parsePagination(c, sosOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if user.Role_Id == 2 {
+			db = db.Where("sos_alerts.resident_id = ?", user.ID)
+		}
+		if status := c.Query("status"); status != "" {
+			db = db.Where("sos_alerts.status = ?", status)
+		}
+		if drill := c.Query("drill"); drill != "" {
+			db = db.Where("sos_alerts.is_drill = ?", drill == "true")
+		}
+		return db
+	}
+
+	var alerts []sosResponse
+	if err := pagination.apply(filter(sosQuery())).Scan(&alerts).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get SOS alerts"})
+		return
+	}
+
+	c.JSON(200, paginate(pagination, alerts, func() int64 {
+		var total int64
+		filter(initializers.DB.Model(&models.SOSAlert{})).Count(&total)
+		return total
+	}))
+}
+
+// GetSOSById gets an SOS with the list of responders
+func GetSOSById(c *gin.Context) {
+	user, _, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	sos, ok := findSOS(c, user)
+	if !ok {
+		return
+	}
+
+	detail, err := getSOSDetail(sos.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get SOS"})
+		return
+	}
+	c.JSON(200, gin.H{"data": detail})
+}
+
+// AcknowledgeSOS records that the authenticated guard or admin is responding, with their optional location.
+// The first acknowledgement sets the response time and tells the resident help is coming.
+func AcknowledgeSOS(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	sos, ok := findSOS(c, user)
+	if !ok {
+		return
+	}
+	if sos.Status != "active" && sos.Status != "acknowledged" {
+		c.JSON(409, gin.H{"message": "SOS is " + sos.Status})
+		return
+	}
+
+	var body struct {
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+	// The body is optional
+	c.ShouldBindJSON(&body)
+	if body.Latitude == nil || body.Longitude == nil || !validCoordinates(*body.Latitude, *body.Longitude) {
+		body.Latitude, body.Longitude = nil, nil
+	}
+
+	var count int64
+	initializers.DB.Model(&models.SOSAcknowledgement{}).Where("sos_id = ? AND user_id = ?", sos.ID, user.ID).Count(&count)
+	if count > 0 {
+		c.JSON(409, gin.H{"message": "You already acknowledged this SOS"})
+		return
+	}
+
+	now := time.Now()
+	first := false
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		ack := models.SOSAcknowledgement{SOS_Id: sos.ID, User_Id: user.ID, Latitude: body.Latitude, Longitude: body.Longitude}
+		if err := tx.Create(&ack).Error; err != nil {
+			return err
+		}
+		// Only the first responder sets the response time, concurrent acknowledgements race on the status
+		responseSeconds := int(now.Sub(sos.CreatedAt).Seconds())
+		result := tx.Model(&models.SOSAlert{}).Where("id = ? AND status = ?", sos.ID, "active").Updates(map[string]interface{}{
+			"status":           "acknowledged",
+			"acknowledged_at":  now,
+			"response_seconds": responseSeconds,
+		})
+		first = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to acknowledge SOS"})
+		return
+	}
+
+	if first {
+		var resident models.User
+		initializers.DB.Select("id, name, phone_no").First(&resident, sos.Resident_Id)
+		sos.Status = "acknowledged"
+		dispatchSOS(*sos, "sos_acknowledged", "Help is on the way",
+			fmt.Sprintf("%s (%s) is responding to your SOS", user.Name, user.Phone_No), userRecipients([]models.User{resident}))
+	}
+
+	detail, _ := getSOSDetail(sos.ID)
+	publishEvent("sos.acknowledged", detail, staffAudience(sos.Resident_Id))
+	c.JSON(200, gin.H{
+		"message": "SOS acknowledged",
+		"data":    detail,
+	})
+}
+
+// ResolveSOS closes an SOS with a note on what happened, {"resolution": "..."}
+func ResolveSOS(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if !staff {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins and security only"})
+		return
+	}
+	sos, ok := findSOS(c, user)
+	if !ok {
+		return
+	}
+	if sos.Status != "active" && sos.Status != "acknowledged" {
+		c.JSON(409, gin.H{"message": "SOS is " + sos.Status})
+		return
+	}
+
+	var body struct {
+		Resolution string `json:"resolution"`
+	}
+	if err := c.BindJSON(&body); err != nil || strings.TrimSpace(body.Resolution) == "" {
+		c.JSON(400, gin.H{"message": "resolution is required"})
+		return
+	}
+
+	err := initializers.DB.Model(sos).Updates(map[string]interface{}{
+		"status":      "resolved",
+		"resolved_at": time.Now(),
+		"resolved_by": user.ID,
+		"resolution":  body.Resolution,
+	}).Error
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to resolve SOS"})
+		return
+	}
+
+	var resident models.User
+	initializers.DB.Select("id, name, phone_no").First(&resident, sos.Resident_Id)
+	dispatchSOS(*sos, "sos_resolved", "SOS resolved", body.Resolution, userRecipients([]models.User{resident}))
+
+	detail, _ := getSOSDetail(sos.ID)
+	publishEvent("sos.resolved", detail, staffAudience(sos.Resident_Id))
+	c.JSON(200, gin.H{
+		"message": "SOS resolved",
+		"data":    detail,
+	})
+}
+
+// CancelSOS lets the resident call off an SOS that has not been resolved, for example a false alarm.
+// The responders are told to stand down.
+func CancelSOS(c *gin.Context) {
+	user, _, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	sos, ok := findSOS(c, user)
+	if !ok {
+		return
+	}
+	if sos.Resident_Id != user.ID {
+		c.JSON(403, gin.H{"message": "Forbidden"})
+		return
+	}
+	if sos.Status != "active" && sos.Status != "acknowledged" {
+		c.JSON(409, gin.H{"message": "SOS is " + sos.Status})
+		return
+	}
+
+	if err := initializers.DB.Model(sos).Updates(map[string]interface{}{"status": "cancelled", "resolved_at": time.Now()}).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to cancel SOS"})
+		return
+	}
+	dispatchSOS(*sos, "sos_cancelled", "SOS cancelled", fmt.Sprintf("%s cancelled the SOS", user.Name), sosResponders())
+
+	detail, _ := getSOSDetail(sos.ID)
+	publishEvent("sos.cancelled", detail, staffAudience(sos.Resident_Id))
+	c.JSON(200, gin.H{
+		"message": "SOS cancelled",
+		"data":    detail,
+	})
+}
+
+// GetSOSSummary reports the number of SOS alerts and the response times of ?month=&year= (default the current month).
+// Drills are only counted with ?drill=true, and then only drills.
+func GetSOSSummary(c *gin.Context) {
+	if !isAdmin(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Admins only"})
+		return
+	}
+
+	now := time.Now().In(jakartaLocation)
+	month, year := int(now.Month()), now.Year()
+	if monthStr, yearStr := c.Query("month"), c.Query("year"); monthStr != "" || yearStr != "" {
+		m, err1 := strconv.Atoi(monthStr)
+		y, err2 := strconv.Atoi(yearStr)
+		if err1 != nil || err2 != nil || m < 1 || m > 12 || y < 1 {
+			c.JSON(400, gin.H{"message": "Invalid month or year"})
+			return
+		}
+		month, year = m, y
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, jakartaLocation)
+	end := start.AddDate(0, 1, 0)
+
+	type CategorySummary struct {
+		Category             string   `json:"category"`
+		Total                int      `json:"total"`
+		Acknowledged         int      `json:"acknowledged"`
+		Resolved             int      `json:"resolved"`
+		Cancelled            int      `json:"cancelled"`
+		AvgResponseSeconds   *float64 `json:"avg_response_seconds"`
+		MaxResponseSeconds   *int     `json:"max_response_seconds"`
+		AvgResolutionMinutes *float64 `json:"avg_resolution_minutes"`
+	}
+	var summary []CategorySummary
+	err := initializers.DB.Model(&models.SOSAlert{}).
+		Select("category, COUNT(*) as total, COUNT(acknowledged_at) as acknowledged, "+
+			"SUM(CASE WHEN status = 'resolved' THEN 1 ELSE 0 END) as resolved, "+
+			"SUM(CASE WHEN status = 'cancelled' THEN 1 ELSE 0 END) as cancelled, "+
+			"AVG(response_seconds) as avg_response_seconds, MAX(response_seconds) as max_response_seconds, "+
+			"AVG(CASE WHEN status = 'resolved' THEN TIMESTAMPDIFF(SECOND, created_at, resolved_at) / 60 END) as avg_resolution_minutes").
+		Where("created_at >= ? AND created_at < ? AND is_drill = ?", start, end, c.Query("drill") == "true").
+		Group("category").
+		Order("category ASC").
+		Scan(&summary).Error
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get SOS summary"})
+		return
+	}
+	if summary == nil {
+		summary = []CategorySummary{}
+	}
+
+	c.JSON(200, gin.H{
+		"month": month,
+		"year":  year,
+		"drill": c.Query("drill") == "true",
+		"data":  summary,
+	})
+}
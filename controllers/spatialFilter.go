@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxSpatialRadius caps ?radius so a typo cannot turn the filter into a full scan
+	maxSpatialRadius = 50000
+	// maxPolygonVertices caps the number of points accepted in ?polygon
+	maxPolygonVertices = 100
+)
+
+// latLng is a point in decimal degrees
+type latLng struct {
+	Lat, Lng float64
+}
+
+// coordinateText is a coordinate in an API response. Coordinates are stored as numbers but the responses
+// keep sending them as strings formatted with %f, "" for a record without coordinates.
+type coordinateText string
+
+// formatCoordinate renders a stored coordinate for a response
+func formatCoordinate(v *float64) coordinateText {
+	if v == nil {
+		return ""
+	}
+	return coordinateText(fmt.Sprintf("%f", *v))
+}
+
+// Scan reads a nullable numeric column
+func (t *coordinateText) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = ""
+	case float64:
+		*t = formatCoordinate(&v)
+	case int64:
+		f := float64(v)
+		*t = formatCoordinate(&f)
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid coordinate %q", v)
+		}
+		*t = formatCoordinate(&f)
+	default:
+		return fmt.Errorf("unsupported coordinate type %T", src)
+	}
+	return nil
+}
+
+// spatialFilter restricts a listing to the records around a point or inside a polygon.
+// Both filters first compare against a bounding box so the location index can be used,
+// the exact test only runs on the rows left.
+type spatialFilter struct {
+	near    *latLng
+	radius  float64 // meters
+	polygon []latLng
+}
+
+// parseSpatialFilter reads the spatial query parameters:
+//
+//	near=lat,lng&radius=R        records within R meters of the point
+//	polygon=lat,lng;lat,lng;...  records inside the polygon (at least 3 points, closing it is optional)
+//
+// Records without coordinates never match a spatial filter.
+func parseSpatialFilter(c *gin.Context) (spatialFilter, error) {
+	var f spatialFilter
+
+	if nearStr := c.Query("near"); nearStr != "" {
+		point, err := parseLatLng(nearStr)
+		if err != nil {
+			return f, fmt.Errorf("Invalid near, use lat,lng")
+		}
+		radius, err := strconv.ParseFloat(c.Query("radius"), 64)
+		if err != nil || radius <= 0 || radius > maxSpatialRadius {
+			return f, fmt.Errorf("radius is required with near (meters, max %d)", maxSpatialRadius)
+		}
+		f.near = &point
+		f.radius = radius
+	} else if c.Query("radius") != "" {
+		return f, fmt.Errorf("radius requires near")
+	}
+
+	if polygonStr := c.Query("polygon"); polygonStr != "" {
+		for _, pair := range strings.Split(polygonStr, ";") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			point, err := parseLatLng(pair)
+			if err != nil {
+				return f, fmt.Errorf("Invalid polygon, use lat,lng;lat,lng;...")
+			}
+			f.polygon = append(f.polygon, point)
+		}
+		// The closing point is added when the polygon is built
+		if n := len(f.polygon); n > 1 && f.polygon[0] == f.polygon[n-1] {
+			f.polygon = f.polygon[:n-1]
+		}
+		if len(f.polygon) < 3 || len(f.polygon) > maxPolygonVertices {
+			return f, fmt.Errorf("polygon needs between 3 and %d points", maxPolygonVertices)
+		}
+	}
+
+	return f, nil
+}
+
+func parseLatLng(s string) (latLng, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return latLng{}, fmt.Errorf("expected lat,lng")
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lng, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil || !validCoordinates(lat, lng) {
+		return latLng{}, fmt.Errorf("invalid coordinates")
+	}
+	return latLng{Lat: lat, Lng: lng}, nil
+}
+
+// apply adds the filters to a query on the security_records table
+func (f spatialFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.near != nil {
+		// One degree of latitude is about 111 km everywhere, a degree of longitude shrinks towards the poles
+		dLat := f.radius / earthRadius * 180 / math.Pi
+		db = db.Where("security_records.latitude BETWEEN ? AND ?", f.near.Lat-dLat, f.near.Lat+dLat)
+		if cosLat := math.Cos(f.near.Lat * math.Pi / 180); cosLat > 0.01 {
+			dLng := dLat / cosLat
+			// Skip the longitude box when it wraps around the antimeridian
+			if f.near.Lng-dLng >= -180 && f.near.Lng+dLng <= 180 {
+				db = db.Where("security_records.longitude BETWEEN ? AND ?", f.near.Lng-dLng, f.near.Lng+dLng)
+			}
+		}
+		// Same haversine formula as distanceMeters
+		db = db.Where("2 * ? * ASIN(LEAST(1, SQRT(POW(SIN(RADIANS(security_records.latitude - ?) / 2), 2) + "+
+			"COS(RADIANS(?)) * COS(RADIANS(security_records.latitude)) * POW(SIN(RADIANS(security_records.longitude - ?) / 2), 2)))) <= ?",
+			earthRadius, f.near.Lat, f.near.Lat, f.near.Lng, f.radius)
+	}
+	if len(f.polygon) > 0 {
+		minLat, maxLat := f.polygon[0].Lat, f.polygon[0].Lat
+		minLng, maxLng := f.polygon[0].Lng, f.polygon[0].Lng
+		for _, p := range f.polygon[1:] {
+			minLat, maxLat = math.Min(minLat, p.Lat), math.Max(maxLat, p.Lat)
+			minLng, maxLng = math.Min(minLng, p.Lng), math.Max(maxLng, p.Lng)
+		}
+		db = db.Where("security_records.latitude BETWEEN ? AND ? AND security_records.longitude BETWEEN ? AND ?", minLat, maxLat, minLng, maxLng)
+		// Planar test in degrees, accurate enough at the size of a neighbourhood
+		db = db.Where("ST_Contains(ST_GeomFromText(?), POINT(security_records.longitude, security_records.latitude))", f.polygonWKT())
+	}
+	return db
+}
+
+// polygonWKT returns the polygon as closed WKT, x is the longitude and y the latitude
+func (f spatialFilter) polygonWKT() string {
+	points := make([]string, 0, len(f.polygon)+1)
+	for i := 0; i <= len(f.polygon); i++ {
+		p := f.polygon[i%len(f.polygon)]
+		points = append(points, strconv.FormatFloat(p.Lng, 'f', -1, 64)+" "+strconv.FormatFloat(p.Lat, 'f', -1, 64))
+	}
+	return "POLYGON((" + strings.Join(points, ", ") + "))"
+}
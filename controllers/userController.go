@@ -11,6 +11,36 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// userExportSelect selects the columns scanned into userExportRow by the CSV/XLSX exports
+const userExportSelect = "users.id, users.name, users.phone_no, users.email, users.address, users.block, roles.role_name, users.created_at"
+
+type userExportRow struct {
+	ID        uint
+	Name      string
+	Phone_No  string
+	Email     string
+	Address   string
+	Block     string
+	Role_Name string
+	CreatedAt time.Time
+}
+
+var userExportColumns = []exportColumn[userExportRow]{
+	{"ID", "ID", func(r userExportRow, _ string) interface{} { return r.ID }},
+	{"Nama", "Name", func(r userExportRow, _ string) interface{} { return r.Name }},
+	{"No. HP", "Phone No.", func(r userExportRow, _ string) interface{} { return r.Phone_No }},
+	{"Email", "Email", func(r userExportRow, _ string) interface{} { return r.Email }},
+	{"Alamat", "Address", func(r userExportRow, _ string) interface{} { return r.Address }},
+	{"Blok", "Block", func(r userExportRow, _ string) interface{} { return r.Block }},
+	{"Peran", "Role", func(r userExportRow, _ string) interface{} { return r.Role_Name }},
+	{"Terdaftar", "Registered", func(r userExportRow, _ string) interface{} { return exportTime(r.CreatedAt) }},
+}
+
+// userOrder lists users by id so pages and cursors stay stable
+var userOrder = sortOrder{
+	{field: sortField{Key: "id", Column: "users.id"}},
+}
+
 func CreateAdminAccount() error {
 	// Check if the role exists
 	var role models.Roles
@@ -50,36 +80,19 @@ func CreateAdminAccount() error {
 }
 
 func GetAllUsers(c *gin.Context) {
-	// Get query parameters for pagination
-	page := c.DefaultQuery("page", "1")    // Default to page 1 if not provided
-	limit := c.DefaultQuery("limit", "10") // Default to 10 records per page if not provided
-
-	// Convert query parameters to integers
-	pageInt, err := strconv.Atoi(page)
-	if err != nil || pageInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid page number",
-		})
-		return
-	}
-
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt < 1 {
-		c.JSON(400, gin.H{
-			"message": "Invalid limit number",
-		})
+	pagination, err := parsePagination(c, userOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
 		return
 	}
 
-	// Calculate the offset
-	offset := (pageInt - 1) * limitInt
-
 	type UserResponse struct {
 		ID       uint   `json:"id"`
 		Phone_No string `json:"phone_no"`
 		Email    string `json:"email"`
 		Name     string `json:"name"`
 		Address  string `json:"address"`
+		Block    string `json:"block"`
 		Role_Id  uint   `json:"role_id"`
 	}
 
@@ -89,11 +102,18 @@ func GetAllUsers(c *gin.Context) {
 	// Retrieve paginated users from the database, with optional name filter
 	var users []UserResponse
 
-	dbQuery := initializers.DB.Model(&models.User{}).Select("id, email, phone_no, name, address, role_id")
+	dbQuery := initializers.DB.Model(&models.User{}).Select("id, email, phone_no, name, address, block, role_id")
 	if nameQuery != "" {
-		dbQuery = dbQuery.Where("name LIKE ?", "%"+nameQuery+"%")
+		dbQuery = dbQuery.Where("users.name LIKE ?", "%"+nameQuery+"%")
+	}
+	if format := exportFormat(c); format != "" {
+		exportQuery := dbQuery.Select(userExportSelect).
+			Joins("left join roles on roles.id = users.role_id").
+			Order("users.name ASC")
+		streamExport(c, format, "users", exportQuery, userExportColumns)
+		return
 	}
-	result := dbQuery.Limit(limitInt).Offset(offset).Scan(&users)
+	result := pagination.apply(dbQuery).Scan(&users)
 	if result.Error != nil {
 		c.JSON(400, gin.H{
 			"message": "Failed to get users",
@@ -102,21 +122,16 @@ func GetAllUsers(c *gin.Context) {
 	}
 
 	// Count the total number of users (with the same filter)
-	var total int64
-	totalQuery := initializers.DB.Model(&models.User{})
-	if nameQuery != "" {
-		totalQuery = totalQuery.Where("name ILIKE ?", "%"+nameQuery+"%")
-	}
-	totalQuery.Count(&total)
-
-	// Return the paginated response
-	c.JSON(200, gin.H{
-		"data":       users,
-		"total":      total,
-		"page":       pageInt,
-		"limit":      limitInt,
-		"totalPages": (total + int64(limitInt) - 1) / int64(limitInt), // Calculate total pages
+	response := paginate(pagination, users, func() int64 {
+		var total int64
+		totalQuery := initializers.DB.Model(&models.User{})
+		if nameQuery != "" {
+			totalQuery = totalQuery.Where("name LIKE ?", "%"+nameQuery+"%")
+		}
+		totalQuery.Count(&total)
+		return total
 	})
+	c.JSON(200, response)
 }
 
 func GetUser(c *gin.Context) {
@@ -226,6 +241,7 @@ func CreateUser(c *gin.Context) {
 		Password      string `json:"password"`
 		Name          string `json:"name"`
 		Address       string `json:"address"`
+		Block         string `json:"block"`
 		Role_Id       uint   `json:"role_id"`
 		FamilyMembers []struct {
 			Name   string `json:"name"`
@@ -255,6 +271,7 @@ func CreateUser(c *gin.Context) {
 		Password: string(hashedPassword),
 		Name:     body.Name,
 		Address:  body.Address,
+		Block:    body.Block,
 		Role_Id:  body.Role_Id,
 	}
 
@@ -292,6 +309,7 @@ func CreateUser(c *gin.Context) {
 		Email    string `json:"email"`
 		Name     string `json:"name"`
 		Address  string `json:"address"`
+		Block    string `json:"block"`
 		Role_Id  uint   `json:"role_id"`
 	}
 
@@ -301,6 +319,7 @@ func CreateUser(c *gin.Context) {
 		Email:    user.Email,
 		Name:     user.Name,
 		Address:  user.Address,
+		Block:    user.Block,
 		Role_Id:  user.Role_Id,
 	}
 
@@ -335,6 +354,7 @@ func UpdateUser(c *gin.Context) {
 		Password      string
 		Name          string
 		Address       string
+		Block         string
 		Role_Id       uint
 		FamilyMembers []struct {
 			ID     uint   `json:"id"` // Include ID to identify existing family members
@@ -355,6 +375,7 @@ func UpdateUser(c *gin.Context) {
 	user.Email = body.Email
 	user.Name = body.Name
 	user.Address = body.Address
+	user.Block = body.Block
 	user.Role_Id = body.Role_Id
 
 	// Hash the password if it is being updated
@@ -424,6 +445,7 @@ func UpdateUser(c *gin.Context) {
 		Email    string `json:"email"`
 		Name     string `json:"name"`
 		Address  string `json:"address"`
+		Block    string `json:"block"`
 		Role_Id  uint   `json:"role_id"`
 	}
 	response := UserResponse{
@@ -432,6 +454,7 @@ func UpdateUser(c *gin.Context) {
 		Email:    user.Email,
 		Name:     user.Name,
 		Address:  user.Address,
+		Block:    user.Block,
 		Role_Id:  user.Role_Id,
 	}
 
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/events"
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/dontkeep/simaling-backend/notify"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// errInviteUsed is returned when an invite was used by another check in in the meantime
+var errInviteUsed = errors.New("invite already used")
+
+// visitOrder lists visits latest check in first, the id keeps cursors unique
+var visitOrder = sortOrder{
+	{field: sortField{Key: "check_in_at", Column: "visits.check_in_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "visits.id"}, desc: true},
+}
+
+type visitResponse struct {
+	ID               uint       `json:"id"`
+	GuestName        string     `json:"guest_name"`
+	VehiclePlate     string     `json:"vehicle_plate"`
+	Destination      string     `json:"destination"`
+	Purpose          string     `json:"purpose"`
+	HostId           *uint      `json:"host_id"`
+	HostName         *string    `json:"host_name"`
+	GuardId          uint       `json:"guard_id"`
+	GuardName        string     `json:"guard_name"`
+	InviteId         *uint      `json:"invite_id"`
+	IdPhoto          string     `json:"id_photo"`
+	IdPhotoThumbnail string     `json:"id_photo_thumbnail"`
+	CheckInAt        time.Time  `json:"check_in_at"`
+	CheckOutAt       *time.Time `json:"check_out_at"`
+}
+
+func visitQuery() *gorm.DB {
+	return initializers.DB.Model(&models.Visit{}).
+		Select("visits.id, visits.guest_name, visits.vehicle_plate, visits.destination, visits.purpose, visits.host_id, " +
+			"hosts.name as host_name, visits.guard_id, guards.name as guard_name, visits.invite_id, " +
+			"visits.id_photo_key as id_photo, visits.id_photo_thumbnail, visits.check_in_at, visits.check_out_at").
+		Joins("left join users hosts on hosts.id = visits.host_id").
+		Joins("left join users guards on guards.id = visits.guard_id")
+}
+
+// visitPhotoURLs turns the storage keys of the ID photo into links, only the staff gets them
+func visitPhotoURLs(visit *visitResponse, staff bool) {
+	if !staff {
+		visit.IdPhoto, visit.IdPhotoThumbnail = "", ""
+		return
+	}
+	visit.IdPhoto = getFullImageURL(visit.IdPhoto)
+	visit.IdPhotoThumbnail = getFullImageURL(visit.IdPhotoThumbnail)
+}
+
+func getVisitResponse(id uint) visitResponse {
+	var visit visitResponse
+	visitQuery().Where("visits.id = ?", id).Scan(&visit)
+	visitPhotoURLs(&visit, true)
+	return visit
+}
+
+// publishVisitEvent sends a visit to the staff and, without the ID photos, to the host
+func publishVisitEvent(eventType string, visit visitResponse) {
+	publishEvent(eventType, visit, staffAudience())
+	if visit.HostId != nil {
+		visitPhotoURLs(&visit, false)
+		publishEvent(eventType, visit, events.Audience{Users: []uint{*visit.HostId}})
+	}
+}
+
+// notifyVisit tells the host about a guest without holding up the request
+func notifyVisit(visit models.Visit, title, body string, recipients []notify.Recipient) {
+	msg := notify.Message{
+		Event:      "visitor",
+		Title:      title,
+		Body:       body,
+		Recipients: recipients,
+		Data:       map[string]interface{}{"visit_id": visit.ID, "invite_id": visit.Invite_Id},
+	}
+	go initializers.Notifier.Send(context.Background(), msg)
+}
+
+// RegisterVisitor checks a guest in at the gate (multipart form): guest_name, vehicle_plate, destination,
+// host_id, purpose, an "id_photo" file and invite_token when the guest shows a QR invite.
+// With an invite the host and the missing guest details come from the invite. The host is notified.
+func RegisterVisitor(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+	guardID, _ := currentUserID(c)
+
+	visit := models.Visit{
+		Guard_Id:      guardID,
+		Destination:   c.PostForm("destination"),
+		Guest_Name:    strings.TrimSpace(c.PostForm("guest_name")),
+		Vehicle_Plate: normalizePlate(c.PostForm("vehicle_plate")),
+		Purpose:       c.PostForm("purpose"),
+		Check_In_At:   time.Now(),
+	}
+	if hostStr := c.PostForm("host_id"); hostStr != "" {
+		hostID, err := strconv.ParseUint(hostStr, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid host_id"})
+			return
+		}
+		var count int64
+		initializers.DB.Model(&models.User{}).Where("id = ? AND role_id = ?", hostID, 2).Count(&count)
+		if count == 0 {
+			c.JSON(400, gin.H{"message": "host_id must be a resident"})
+			return
+		}
+		id := uint(hostID)
+		visit.Host_Id = &id
+	}
+
+	var invite models.VisitorInvite
+	if token := strings.TrimSpace(c.PostForm("invite_token")); token != "" {
+		if err := initializers.DB.Where("token = ?", token).First(&invite).Error; err != nil {
+			c.JSON(400, gin.H{"message": "Invalid invite"})
+			return
+		}
+		if status := visitorInviteStatus(invite, visit.Check_In_At); status != "active" {
+			c.JSON(400, gin.H{"message": "The invite is " + status})
+			return
+		}
+		visit.Invite_Id = &invite.ID
+		visit.Host_Id = &invite.Host_Id
+		if visit.Guest_Name == "" {
+			visit.Guest_Name = invite.Guest_Name
+		}
+		if visit.Vehicle_Plate == "" {
+			visit.Vehicle_Plate = invite.Vehicle_Plate
+		}
+		if visit.Purpose == "" {
+			visit.Purpose = invite.Purpose
+		}
+	}
+	if visit.Guest_Name == "" {
+		c.JSON(400, gin.H{"message": "guest_name is required"})
+		return
+	}
+	if visit.Host_Id == nil && visit.Destination == "" {
+		c.JSON(400, gin.H{"message": "destination or host_id is required"})
+		return
+	}
+	if visit.Destination == "" && visit.Host_Id != nil {
+		var host models.User
+		initializers.DB.Select("address").First(&host, *visit.Host_Id)
+		visit.Destination = host.Address
+	}
+
+	if file, err := c.FormFile("id_photo"); err == nil {
+		visit.Id_Photo_Key, visit.Id_Photo_Thumbnail, err = saveUploadedImage(c, file, "visitors")
+		if err != nil {
+			if message := uploadErrorMessage(err); message != "" {
+				c.JSON(400, gin.H{"message": message})
+				return
+			}
+			c.JSON(500, gin.H{"message": "Failed to save ID photo"})
+			return
+		}
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if visit.Invite_Id != nil {
+			// The condition on used_at makes a concurrent second check in with the same invite fail
+			result := tx.Model(&models.VisitorInvite{}).Where("id = ? AND used_at IS NULL", invite.ID).Update("used_at", visit.Check_In_At)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errInviteUsed
+			}
+		}
+		return tx.Create(&visit).Error
+	})
+	if err != nil {
+		deleteUploadedImage(c, visit.Id_Photo_Key, visit.Id_Photo_Thumbnail)
+		if err == errInviteUsed {
+			c.JSON(400, gin.H{"message": "The invite is used"})
+			return
+		}
+		c.JSON(500, gin.H{"message": "Failed to register visitor"})
+		return
+	}
+
+	response := getVisitResponse(visit.ID)
+	if visit.Host_Id != nil {
+		var host models.User
+		initializers.DB.Select("id, name, phone_no").First(&host, *visit.Host_Id)
+		text := fmt.Sprintf("%s is at the gate", visit.Guest_Name)
+		if visit.Invite_Id != nil {
+			text = fmt.Sprintf("Your invited guest %s has arrived", visit.Guest_Name)
+		}
+		if visit.Purpose != "" {
+			text += " (" + visit.Purpose + ")"
+		}
+		notifyVisit(visit, "Visitor arrived", text, userRecipients([]models.User{host}))
+	}
+	publishVisitEvent("visitor.checked_in", response)
+
+	c.JSON(200, gin.H{
+		"message": "Visitor checked in",
+		"data":    response,
+	})
+}
+
+// CheckOutVisitor records when a guest left
+func CheckOutVisitor(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+	guardID, _ := currentUserID(c)
+
+	var visit models.Visit
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&visit).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Visit not found"})
+		return
+	}
+	if visit.Check_Out_At != nil {
+		c.JSON(409, gin.H{"message": "Visitor already checked out"})
+		return
+	}
+	if err := initializers.DB.Model(&visit).Updates(map[string]interface{}{"check_out_at": time.Now(), "check_out_by": guardID}).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to check out visitor"})
+		return
+	}
+
+	response := getVisitResponse(visit.ID)
+	publishVisitEvent("visitor.checked_out", response)
+	c.JSON(200, gin.H{
+		"message": "Visitor checked out",
+		"data":    response,
+	})
+}
+
+// GetVisitors searches the visitor history.
+//
+//	q         part of the guest name, vehicle plate or destination
+//	host_id   visits to one resident
+//	from, to  check in date range (YYYY-MM-DD)
+//	on_site   true for guests who have not checked out
+//
+// Residents only get the visits to themselves, without the ID photos.
+func GetVisitors(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+
+	pagination, err := parsePagination(c, visitOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	var from, to time.Time
+	if fromStr := c.Query("from"); fromStr != "" {
+		if from, err = time.ParseInLocation("2006-01-02", fromStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid from date. Use YYYY-MM-DD."})
+			return
+		}
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		if to, err = time.ParseInLocation("2006-01-02", toStr, jakartaLocation); err != nil {
+			c.JSON(400, gin.H{"message": "Invalid to date. Use YYYY-MM-DD."})
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if !staff {
+			db = db.Where("visits.host_id = ?", user.ID)
+		} else if hostID := c.Query("host_id"); hostID != "" {
+			db = db.Where("visits.host_id = ?", hostID)
+		}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			like := "%" + escapeLike(q) + "%"
+			db = db.Where("visits.guest_name LIKE ? OR visits.vehicle_plate LIKE ? OR visits.destination LIKE ?", like, "%"+escapeLike(normalizePlate(q))+"%", like)
+		}
+		if !from.IsZero() {
+			db = db.Where("visits.check_in_at >= ?", from)
+		}
+		if !to.IsZero() {
+			db = db.Where("visits.check_in_at < ?", to)
+		}
+		if c.Query("on_site") == "true" {
+			db = db.Where("visits.check_out_at IS NULL")
+		}
+		return db
+	}
+
+	var visits []visitResponse
+	if err := pagination.apply(filter(visitQuery())).Scan(&visits).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get visitors"})
+		return
+	}
+	for i := range visits {
+		visitPhotoURLs(&visits[i], staff)
+	}
+
+	c.JSON(200, paginate(pagination, visits, func() int64 {
+		var total int64
+		filter(initializers.DB.Model(&models.Visit{})).Count(&total)
+		return total
+	}))
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const (
+	// visitorInviteTokenPrefix starts the text in the QR code of an invite, so the guard app can tell it from a checkpoint code
+	visitorInviteTokenPrefix = "SIMALING-INV:"
+	// maxVisitorInviteValidity limits how long an invite can be used
+	maxVisitorInviteValidity = 7 * 24 * time.Hour
+)
+
+// visitorInviteOrder lists invites newest first, the id keeps cursors unique
+var visitorInviteOrder = sortOrder{
+	{field: sortField{Key: "created_at", Column: "visitor_invites.created_at", IsTime: true}, desc: true},
+	{field: sortField{Key: "id", Column: "visitor_invites.id"}, desc: true},
+}
+
+type visitorInviteResponse struct {
+	ID           uint       `json:"id"`
+	HostId       uint       `json:"host_id"`
+	HostName     string     `json:"host_name"`
+	GuestName    string     `json:"guest_name"`
+	VehiclePlate string     `json:"vehicle_plate"`
+	Purpose      string     `json:"purpose"`
+	ValidFrom    time.Time  `json:"valid_from"`
+	ValidUntil   time.Time  `json:"valid_until"`
+	Token        string     `json:"token,omitempty"`
+	UsedAt       *time.Time `json:"used_at"`
+	Status       string     `json:"status"` // "active", "used", "expired" or "upcoming"
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func toVisitorInviteResponse(invite models.VisitorInvite, hostName string, withToken bool) visitorInviteResponse {
+	response := visitorInviteResponse{
+		ID:           invite.ID,
+		HostId:       invite.Host_Id,
+		HostName:     hostName,
+		GuestName:    invite.Guest_Name,
+		VehiclePlate: invite.Vehicle_Plate,
+		Purpose:      invite.Purpose,
+		ValidFrom:    invite.Valid_From,
+		ValidUntil:   invite.Valid_Until,
+		UsedAt:       invite.Used_At,
+		Status:       visitorInviteStatus(invite, time.Now()),
+		CreatedAt:    invite.CreatedAt,
+	}
+	if withToken {
+		response.Token = invite.Token
+	}
+	return response
+}
+
+func visitorInviteStatus(invite models.VisitorInvite, now time.Time) string {
+	switch {
+	case invite.Used_At != nil:
+		return "used"
+	case now.After(invite.Valid_Until):
+		return "expired"
+	case now.Before(invite.Valid_From):
+		return "upcoming"
+	}
+	return "active"
+}
+
+// normalizePlate turns "b 1234 xyz" into "B 1234 XYZ" so plates can be searched
+func normalizePlate(plate string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(plate)), " ")
+}
+
+// findVisitorInvite loads the invite in the URL, residents only find their own
+func findVisitorInvite(c *gin.Context, user models.User) (*models.VisitorInvite, bool) {
+	db := initializers.DB.Where("id = ?", c.Param("id"))
+	if user.Role_Id != 1 {
+		db = db.Where("host_id = ?", user.ID)
+	}
+	var invite models.VisitorInvite
+	if err := db.First(&invite).Error; err != nil {
+		c.JSON(404, gin.H{"message": "Invite not found"})
+		return nil, false
+	}
+	return &invite, true
+}
+
+// CreateVisitorInvite lets a resident pre-approve a guest, the response holds the token for the QR code:
+//
+//	{"guest_name": "Budi", "vehicle_plate": "B 1234 XYZ", "purpose": "Arisan", "valid_from": "...", "valid_until": "..."}
+//
+// The invite is valid from now for 24 hours unless valid_from and valid_until are given (at most 7 days).
+func CreateVisitorInvite(c *gin.Context) {
+	if !userVerification(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Residents only"})
+		return
+	}
+	uid, _ := currentUserID(c)
+
+	var body struct {
+		GuestName    string     `json:"guest_name"`
+		VehiclePlate string     `json:"vehicle_plate"`
+		Purpose      string     `json:"purpose"`
+		ValidFrom    *time.Time `json:"valid_from"`
+		ValidUntil   *time.Time `json:"valid_until"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(400, gin.H{"message": "Invalid request body"})
+		return
+	}
+	if strings.TrimSpace(body.GuestName) == "" {
+		c.JSON(400, gin.H{"message": "guest_name is required"})
+		return
+	}
+	validFrom := time.Now()
+	if body.ValidFrom != nil {
+		validFrom = *body.ValidFrom
+	}
+	validUntil := validFrom.Add(24 * time.Hour)
+	if body.ValidUntil != nil {
+		validUntil = *body.ValidUntil
+	}
+	if !validUntil.After(validFrom) || validUntil.Sub(validFrom) > maxVisitorInviteValidity || validUntil.Before(time.Now()) {
+		c.JSON(400, gin.H{"message": "valid_until must be in the future, after valid_from and at most 7 days later"})
+		return
+	}
+
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create invite"})
+		return
+	}
+	invite := models.VisitorInvite{
+		Host_Id:       uid,
+		Guest_Name:    strings.TrimSpace(body.GuestName),
+		Vehicle_Plate: normalizePlate(body.VehiclePlate),
+		Purpose:       body.Purpose,
+		Valid_From:    validFrom,
+		Valid_Until:   validUntil,
+		Token:         visitorInviteTokenPrefix + base64.RawURLEncoding.EncodeToString(b),
+	}
+	if err := initializers.DB.Create(&invite).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create invite"})
+		return
+	}
+
+	var host models.User
+	initializers.DB.Select("name").First(&host, uid)
+	c.JSON(200, gin.H{
+		"message": "Invite created",
+		"data":    toVisitorInviteResponse(invite, host.Name, true),
+	})
+}
+
+// GetVisitorInvites lists the invites of the authenticated resident, or all invites for admins.
+// ?active=true only lists invites that can still be used.
+func GetVisitorInvites(c *gin.Context) {
+	user, staff, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	if staff && user.Role_Id != 1 {
+		c.JSON(403, gin.H{"message": "Forbidden: Residents and admins only"})
+		return
+	}
+
+	pagination, err := parsePagination(c, visitorInviteOrder)
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if user.Role_Id != 1 {
+			db = db.Where("host_id = ?", user.ID)
+		}
+		if c.Query("active") == "true" {
+			db = db.Where("used_at IS NULL AND valid_until > ?", time.Now())
+		}
+		return db
+	}
+
+	var invites []models.VisitorInvite
+	db := initializers.DB.Preload("Host", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id, name")
+	})
+	if err := pagination.apply(filter(db)).Find(&invites).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to get invites"})
+		return
+	}
+	response := make([]visitorInviteResponse, len(invites))
+	for i, invite := range invites {
+		response[i] = toVisitorInviteResponse(invite, invite.Host.Name, invite.Host_Id == user.ID)
+	}
+
+	c.JSON(200, paginate(pagination, response, func() int64 {
+		var total int64
+		filter(initializers.DB.Model(&models.VisitorInvite{})).Count(&total)
+		return total
+	}))
+}
+
+// GetVisitorInviteQR returns the QR code of an invite as a PNG image (?size= in pixels, default 512),
+// for the host to send to the guest
+func GetVisitorInviteQR(c *gin.Context) {
+	user, _, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	invite, ok := findVisitorInvite(c, user)
+	if !ok {
+		return
+	}
+	if invite.Host_Id != user.ID {
+		c.JSON(403, gin.H{"message": "Forbidden: Only the host can share the invite"})
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery("size", "512"))
+	if err != nil || size < 128 || size > 2048 {
+		c.JSON(400, gin.H{"message": "Invalid size, use 128 to 2048"})
+		return
+	}
+	png, err := qrcode.Encode(invite.Token, qrcode.Medium, size)
+	if err != nil {
+		c.JSON(500, gin.H{"message": "Failed to create QR code"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="invite-%d.png"`, invite.ID))
+	c.Data(200, "image/png", png)
+}
+
+// DeleteVisitorInvite cancels an invite that has not been used
+func DeleteVisitorInvite(c *gin.Context) {
+	user, _, ok := staffOrResident(c)
+	if !ok {
+		return
+	}
+	invite, ok := findVisitorInvite(c, user)
+	if !ok {
+		return
+	}
+	if invite.Used_At != nil {
+		c.JSON(409, gin.H{"message": "The invite has already been used"})
+		return
+	}
+	if err := initializers.DB.Delete(invite).Error; err != nil {
+		c.JSON(500, gin.H{"message": "Failed to cancel invite"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Invite cancelled"})
+}
+
+// LookupVisitorInvite shows a guard what a scanned invite is for (?token=), before checking the guest in
+func LookupVisitorInvite(c *gin.Context) {
+	if !isSecurity(c) {
+		c.JSON(403, gin.H{"message": "Forbidden: Security only"})
+		return
+	}
+
+	var invite models.VisitorInvite
+	err := initializers.DB.Preload("Host", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id, name")
+	}).Where("token = ?", strings.TrimSpace(c.Query("token"))).First(&invite).Error
+	if err != nil {
+		c.JSON(404, gin.H{"message": "Invite not found"})
+		return
+	}
+	c.JSON(200, gin.H{"data": toVisitorInviteResponse(invite, invite.Host.Name, false)})
+}
@@ -0,0 +1,71 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Event is something that happened in the application, pushed to the connected clients.
+// The whole event, audience included, is JSON serializable so a broker can carry it between instances.
+type Event struct {
+	ID       uint64      `json:"id"`
+	Type     string      `json:"type"` // Such as "security_record.created" or "sos.raised"
+	Time     time.Time   `json:"time"`
+	Data     interface{} `json:"data"`
+	Audience Audience    `json:"audience"`
+}
+
+// ClientEvent is what the clients receive of an event, the audience stays on the server
+type ClientEvent struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Client returns the event without its audience
+func (e Event) Client() ClientEvent {
+	return ClientEvent{ID: e.ID, Type: e.Type, Time: e.Time, Data: e.Data}
+}
+
+// Audience selects who receives an event: the users in Users, every user with a role in Roles,
+// and the users with a role in BlockRoles who live in Block.
+type Audience struct {
+	Users      []uint `json:"users,omitempty"`
+	Roles      []uint `json:"roles,omitempty"`
+	BlockRoles []uint `json:"block_roles,omitempty"`
+	Block      string `json:"block,omitempty"`
+}
+
+// Includes checks if a user with the given role and block is part of the audience
+func (a Audience) Includes(userID, roleID uint, block string) bool {
+	for _, id := range a.Users {
+		if id == userID {
+			return true
+		}
+	}
+	for _, id := range a.Roles {
+		if id == roleID {
+			return true
+		}
+	}
+	if a.Block == "" || a.Block != block {
+		return false
+	}
+	for _, id := range a.BlockRoles {
+		if id == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// Broker delivers published events to every subscriber. Memory works inside one process,
+// a backend running several instances needs a broker backed by a shared bus (Redis, NATS, ...).
+type Broker interface {
+	// Publish sends the event to the current subscribers, the broker sets its ID and Time
+	Publish(ctx context.Context, e Event) error
+	// Subscribe returns a channel receiving the events published from now on.
+	// The channel is closed when ctx is done.
+	Subscribe(ctx context.Context) (<-chan Event, error)
+}
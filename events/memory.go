@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Memory is a Broker for a single instance. Subscribers that do not keep up miss events
+// instead of slowing down the publisher, clients can spot the gap in the event IDs.
+type Memory struct {
+	mu     sync.RWMutex
+	subs   map[chan Event]struct{}
+	seq    atomic.Uint64
+	buffer int
+}
+
+// NewMemory creates a broker buffering up to buffer events per subscriber
+func NewMemory(buffer int) *Memory {
+	return &Memory{subs: make(map[chan Event]struct{}), buffer: buffer}
+}
+
+func (m *Memory) Publish(_ context.Context, e Event) error {
+	e.ID = m.seq.Add(1)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for ch := range m.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, m.buffer)
+	m.mu.Lock()
+	m.subs[ch] = struct{}{}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subs, ch)
+		m.mu.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
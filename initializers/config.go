@@ -1,18 +1,105 @@
 package initializers
 
-import "os"
+import (
+	"crypto/rand"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 var AppConfig struct {
-    BaseURL string
+	BaseURL       string
+	RTHeadName    string
+	RTHeadPhone   string
+	TreasurerName string
+	UploadMaxSize int64
+
+	UploadCleanupInterval time.Duration
+	UploadCleanupGrace    time.Duration
+	UploadCleanupDryRun   bool
+
+	GPSMaxAccuracy float64
+
+	CheckpointTokenSecret []byte
+
+	PatrolMonitorInterval   time.Duration
+	PatrolInactivityTimeout time.Duration
+	PatrolAlertEscalation   time.Duration
 }
 
 func LoadConfig() {
-    AppConfig.BaseURL = os.Getenv("BASE_URL")
-    // Default value if BASE_URL is not set
+	AppConfig.BaseURL = os.Getenv("BASE_URL")
+	// Default value if BASE_URL is not set
 
 	// Set a value with real domain or IP address for production
 	// or use localhost/local IP for development
-    if AppConfig.BaseURL == "" {
-        AppConfig.BaseURL = "http://localhost:3004"
-    }
-}
\ No newline at end of file
+	if AppConfig.BaseURL == "" {
+		AppConfig.BaseURL = "http://localhost:3004"
+	}
+
+	// Names printed in the signature block of the financial reports
+	AppConfig.RTHeadName = os.Getenv("RT_HEAD_NAME")
+	AppConfig.TreasurerName = os.Getenv("TREASURER_NAME")
+	// Unacknowledged patrol alerts are escalated to this number, or to the admins when it is not set
+	AppConfig.RTHeadPhone = os.Getenv("RT_HEAD_PHONE")
+
+	// Maximum size of an uploaded file, 10 MB unless UPLOAD_MAX_SIZE_MB is set
+	AppConfig.UploadMaxSize = 10 << 20
+	if mb, err := strconv.Atoi(os.Getenv("UPLOAD_MAX_SIZE_MB")); err == nil && mb > 0 {
+		AppConfig.UploadMaxSize = int64(mb) << 20
+	}
+
+	// Orphaned uploads are removed every UPLOAD_CLEANUP_INTERVAL (a Go duration such as "24h"),
+	// the job is off when it is not set. Files younger than UPLOAD_CLEANUP_GRACE are never removed.
+	AppConfig.UploadCleanupInterval = parseDurationEnv("UPLOAD_CLEANUP_INTERVAL", 0)
+	AppConfig.UploadCleanupGrace = parseDurationEnv("UPLOAD_CLEANUP_GRACE", 24*time.Hour)
+	AppConfig.UploadCleanupDryRun = os.Getenv("UPLOAD_CLEANUP_DRY_RUN") == "true"
+
+	// Security records with a reported GPS accuracy worse than this (in meters) are flagged
+	AppConfig.GPSMaxAccuracy = 50
+	if meters, err := strconv.ParseFloat(os.Getenv("GPS_MAX_ACCURACY_METERS"), 64); err == nil && meters > 0 {
+		AppConfig.GPSMaxAccuracy = meters
+	}
+
+	// Key signing the checkpoint QR codes, printed codes stop working when it changes
+	AppConfig.CheckpointTokenSecret = signingSecret("CHECKPOINT_TOKEN_SECRET")
+
+	// The patrol monitor runs every PATROL_MONITOR_INTERVAL ("0" turns it off) and raises an alert when a guard
+	// on shift sends no security record for PATROL_INACTIVITY_TIMEOUT or a patrol round is missed.
+	// Alerts nobody acknowledged within PATROL_ALERT_ESCALATION are escalated to the RT head.
+	AppConfig.PatrolMonitorInterval = parseDurationEnv("PATROL_MONITOR_INTERVAL", time.Minute)
+	AppConfig.PatrolInactivityTimeout = parseDurationEnv("PATROL_INACTIVITY_TIMEOUT", time.Hour)
+	AppConfig.PatrolAlertEscalation = parseDurationEnv("PATROL_ALERT_ESCALATION", 15*time.Minute)
+}
+
+func parseDurationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %s: %q", name, value)
+	}
+	return d
+}
+
+// signingSecret reads the key of an environment variable used to sign tokens or links.
+// A random key only lasts until the next restart and differs between instances, which silently
+// breaks everything signed with it, so it is only used in debug mode and release builds refuse to start.
+func signingSecret(name string) []byte {
+	secret := []byte(os.Getenv(name))
+	if len(secret) > 0 {
+		return secret
+	}
+	if !gin.IsDebugging() {
+		log.Fatalf("%s is not set", name)
+	}
+	log.Printf("%s is not set, using a random secret (debug mode only)", name)
+	secret = make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
@@ -0,0 +1,12 @@
+package initializers
+
+import "github.com/dontkeep/simaling-backend/events"
+
+// Events carries the domain events pushed to clients over /api/events
+var Events events.Broker
+
+// EventsConnection sets up the event broker. Only the in-process broker exists for now,
+// which is enough as long as a single instance of the backend runs.
+func EventsConnection() {
+	Events = events.NewMemory(64)
+}
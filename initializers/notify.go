@@ -0,0 +1,15 @@
+package initializers
+
+import "github.com/dontkeep/simaling-backend/notify"
+
+var Notifier *notify.Dispatcher
+
+// NotifierConnection sets up the notification channels. Messages are always logged,
+// NOTIFY_WEBHOOK_URL additionally posts them to a gateway (signed with NOTIFY_WEBHOOK_SECRET if set).
+func NotifierConnection() {
+	channels := []notify.Channel{notify.Log{}}
+	if url := GetEnv("NOTIFY_WEBHOOK_URL", ""); url != "" {
+		channels = append(channels, notify.NewWebhook(url, []byte(GetEnv("NOTIFY_WEBHOOK_SECRET", ""))))
+	}
+	Notifier = notify.NewDispatcher(channels...)
+}
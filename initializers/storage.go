@@ -0,0 +1,47 @@
+package initializers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/storage"
+)
+
+var Storage storage.Storage
+
+// FileURLSigner signs the download links of the local storage backend
+var FileURLSigner *storage.URLSigner
+
+// StorageConnection sets up the backend for uploaded files, selected with STORAGE_DRIVER ("local" or "s3").
+// It must run after LoadConfig because local files are linked under the base URL.
+func StorageConnection() {
+	secret := signingSecret("FILE_URL_SECRET")
+	ttlMinutes, err := strconv.Atoi(GetEnv("FILE_URL_TTL_MINUTES", "60"))
+	if err != nil || ttlMinutes < 1 {
+		log.Fatal("Invalid FILE_URL_TTL_MINUTES")
+	}
+	FileURLSigner = storage.NewURLSigner(secret, time.Duration(ttlMinutes)*time.Minute)
+
+	switch driver := GetEnv("STORAGE_DRIVER", "local"); driver {
+	case "local":
+		Storage, err = storage.NewLocal(GetEnv("STORAGE_LOCAL_DIR", "uploads"), AppConfig.BaseURL+"/files", FileURLSigner)
+	case "s3":
+		Storage, err = storage.NewS3(context.Background(), storage.S3Config{
+			Endpoint:  GetEnv("S3_ENDPOINT", "localhost:9000"),
+			Region:    GetEnv("S3_REGION", "us-east-1"),
+			Bucket:    GetEnv("S3_BUCKET", "simaling"),
+			AccessKey: GetEnv("S3_ACCESS_KEY", ""),
+			SecretKey: GetEnv("S3_SECRET_KEY", ""),
+			UseSSL:    GetEnv("S3_USE_SSL", "false") == "true",
+			URLExpiry: time.Duration(ttlMinutes) * time.Minute,
+		})
+	default:
+		log.Fatalf("Unknown STORAGE_DRIVER %q", driver)
+	}
+
+	if err != nil {
+		log.Fatalf("Error connecting to storage: %v", err)
+	}
+}
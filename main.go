@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/dontkeep/simaling-backend/controllers"
 	"github.com/dontkeep/simaling-backend/initializers"
+	"github.com/dontkeep/simaling-backend/maintenance"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +15,9 @@ func init() {
 	initializers.LoadEnvVar()
 	initializers.DatabaseConnection()
 	initializers.LoadConfig()
+	initializers.StorageConnection()
+	initializers.NotifierConnection()
+	initializers.EventsConnection()
 	if err := controllers.CreateDefaultRoles(); err != nil {
 		log.Fatalf("Failed to create default roles: %v", err)
 	}
@@ -23,10 +28,23 @@ func init() {
 }
 
 func main() {
+	if interval := initializers.AppConfig.UploadCleanupInterval; interval > 0 {
+		go maintenance.ScheduleUploadCleanup(context.Background(), initializers.DB, initializers.Storage,
+			interval, initializers.AppConfig.UploadCleanupGrace, initializers.AppConfig.UploadCleanupDryRun)
+	}
+
+	if interval := initializers.AppConfig.PatrolMonitorInterval; interval > 0 {
+		go controllers.MonitorPatrols(context.Background(), interval)
+	}
+
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	r.Static("/uploads", "./uploads")
+	// Uploaded files are only reachable through signed, expiring links
+	r.GET("/files/*key", controllers.ServeSignedFile)
+
+	// Server-Sent Events, outside the group so EventSource clients can authenticate with ?ticket=
+	r.GET("/api/events", controllers.EventStreamTicket, controllers.ExtractTokenMiddleware, controllers.Authenticate, controllers.StreamEvents)
 
 	r.POST("/login", controllers.Login)
 	r.GET("/", controllers.GetRoot)
@@ -34,10 +52,11 @@ func main() {
 
 	authorized.Use(controllers.ExtractTokenMiddleware, controllers.Authenticate)
 	{
+		// POST routes wrapped with controllers.Idempotency accept an Idempotency-Key header
 		// User management
 		authorized.GET("/users", controllers.GetAllUsers)
 		authorized.GET("/users/:id", controllers.GetUser)
-		authorized.POST("/users", controllers.CreateUser)
+		authorized.POST("/users", controllers.Idempotency, controllers.CreateUser)
 		authorized.PUT("/users/:id", controllers.UpdateUser)
 		authorized.DELETE("/users/:id", controllers.DeleteUser)
 
@@ -47,8 +66,15 @@ func main() {
 		// Funds management
 		authorized.GET("/funds", controllers.GetFunds)
 		authorized.GET("/funds/:id", controllers.GetFundsById)
+		authorized.GET("/funds/:id/image", controllers.GetFundsImage) // Owner or admin
+		authorized.GET("/funds/:id/attachments", controllers.GetFundsAttachments)
+		authorized.POST("/funds/:id/attachments", controllers.AddFundsAttachment)
+		authorized.PUT("/funds/:id/attachments/order", controllers.ReorderFundsAttachments)
+		authorized.PUT("/funds/:id/attachments/:attachmentId", controllers.UpdateFundsAttachment)
+		authorized.DELETE("/funds/:id/attachments/:attachmentId", controllers.DeleteFundsAttachment)
+		authorized.GET("/funds/:id/attachments/:attachmentId/file", controllers.GetFundsAttachmentFile) // ?variant=thumbnail
 		authorized.GET("/funds-by-user", controllers.GetFundsByUser)
-		authorized.POST("/funds", controllers.CreateFunds)
+		authorized.POST("/funds", controllers.Idempotency, controllers.CreateFunds)
 		authorized.PUT("/funds/:id", controllers.UpdateFunds)
 		authorized.DELETE("/funds/:id", controllers.DeleteFunds)
 		authorized.PUT("/funds/:id/accept", controllers.AcceptFunds)
@@ -57,14 +83,96 @@ func main() {
 		authorized.GET("/funds-income", controllers.GetIncomeFunds)
 		authorized.GET("/funds-expense", controllers.GetExpenseFunds)
 
+		// Financial reports
+		authorized.GET("/reports/funds", controllers.GetFundsReport) // Admin-only: ?year=&month=|quarter=&format=pdf|xlsx|json
+
 		// Security records management
-		authorized.GET("/security-records", controllers.GetAllSecurityRecord)                            // Admin-only
-		authorized.POST("/security-records", controllers.CreateSecurityRecord)                           // Security-only (legacy)
-		authorized.POST("/security-records/add", controllers.AddSecurityRecord)                          // Security-only (new)
-		authorized.GET("/security-records/by-day", controllers.GetSecurityRecordByDay)                   // User-only: get security records by day
-		authorized.GET("/security-records/by-user", controllers.GetSecurityRecordByUser)                 // Security-only: get own records
-		authorized.DELETE("/security-records/:id", controllers.DeleteSecurityRecord)                     // Admin-only
-		authorized.GET("/security-records/by-user-by-day", controllers.GetSecurityRecordByUserAndByDate) // User-only: get security records by user and day
+		authorized.GET("/security-records", controllers.GetAllSecurityRecord)                                // Admin-only: ?flagged=&near=&radius=&polygon=, with photos
+		authorized.POST("/v2/security-records", controllers.Idempotency, controllers.CreateSecurityRecordV2) // Security-only: purpose=patrol|resident_visit, JSON with photo_ids or multipart form with "photos"
+		authorized.POST("/security-records/photos", controllers.UploadSecurityRecordPhoto)                   // Security-only: upload a photo ahead, its id goes into photo_ids
+		authorized.POST("/security-records/sync", controllers.SyncSecurityRecords)                           // Security-only: records captured offline, deduplicated by client_id
+		authorized.PUT("/security-records/:id/review-sync", controllers.ReviewSyncedSecurityRecord)          // Admin-only: a late synced record counts for the patrol schedule
+		authorized.POST("/security-records/scan", controllers.Idempotency, controllers.ScanCheckpoint)       // Security-only: scan of a checkpoint QR code or NFC tag
+		authorized.GET("/security-records/by-day", controllers.GetSecurityRecordByDay)                       // User-only: get security records by day
+		authorized.GET("/security-records/by-user", controllers.GetSecurityRecordByUser)                     // Security-only: get own records
+		authorized.GET("/security-records/track", controllers.GetPatrolTrack)                                // Admin: ?security_id=&from=&to=&format=geojson|gpx, security: own track
+		authorized.DELETE("/security-records/:id", controllers.DeleteSecurityRecord)                         // Admin-only
+		authorized.GET("/security-records/by-user-by-day", controllers.GetSecurityRecordByUserAndByDate)     // User-only: get security records by user and day
+
+		// Deprecated v1 security record endpoints, replaced by /v2/security-records
+		securityRecordsV1 := controllers.Deprecated("/api/v2/security-records")
+		authorized.POST("/security-records", securityRecordsV1, controllers.Idempotency, controllers.CreateSecurityRecord)  // Security-only: phone_no of the visited resident
+		authorized.POST("/security-records/add", securityRecordsV1, controllers.Idempotency, controllers.AddSecurityRecord) // Security-only: patrol visit
+		authorized.GET("/deprecated-routes", controllers.GetDeprecatedRouteUsage)                                           // Admin-only: ?days= calls to deprecated routes per user and client
+
+		// Patrol checkpoints
+		authorized.GET("/checkpoints", controllers.GetCheckpoints)                          // Admin and security: ?active=true
+		authorized.GET("/checkpoints/:id", controllers.GetCheckpointById)                   // Admin and security
+		authorized.POST("/checkpoints", controllers.CreateCheckpoint)                       // Admin-only
+		authorized.PUT("/checkpoints/:id", controllers.UpdateCheckpoint)                    // Admin-only
+		authorized.DELETE("/checkpoints/:id", controllers.DeleteCheckpoint)                 // Admin-only
+		authorized.GET("/checkpoints/:id/qr", controllers.GetCheckpointQR)                  // Admin-only: PNG to print, ?format=text for NFC tags
+		authorized.POST("/checkpoints/:id/rotate-token", controllers.RotateCheckpointToken) // Admin-only: invalidates the printed code
+
+		// Patrol routes and schedule compliance
+		authorized.GET("/patrol-routes", controllers.GetPatrolRoutes)                        // Admin and security: ?active=true
+		authorized.GET("/patrol-routes/:id", controllers.GetPatrolRouteById)                 // Admin and security
+		authorized.POST("/patrol-routes", controllers.CreatePatrolRoute)                     // Admin-only
+		authorized.PUT("/patrol-routes/:id", controllers.UpdatePatrolRoute)                  // Admin-only
+		authorized.DELETE("/patrol-routes/:id", controllers.DeletePatrolRoute)               // Admin-only
+		authorized.GET("/patrol-compliance", controllers.GetPatrolCompliance)                // Admin: ?date=&route_id=&security_id=, security: own rounds
+		authorized.GET("/patrol-coverage", controllers.GetPatrolCoverage)                    // Admin-only: ?from=&to=&cell= visits by block, hour and guard with a heatmap
+		authorized.GET("/patrol-alerts", controllers.GetPatrolAlerts)                        // Admin and security: ?status=&type=
+		authorized.PUT("/patrol-alerts/:id/acknowledge", controllers.AcknowledgePatrolAlert) // Admin and other guards (missed patrols admin-only): stops the escalation
+		authorized.PUT("/patrol-alerts/:id/resolve", controllers.ResolvePatrolAlert)         // Admin-only
+
+		// Incidents
+		authorized.GET("/incidents", controllers.GetIncidents)                               // Staff: ?status=&type=&severity=&block=&assigned=me, residents: own block
+		authorized.GET("/incidents/:id", controllers.GetIncidentById)                        // Staff, residents of the block
+		authorized.POST("/incidents", controllers.CreateIncident)                            // Admin and security: multipart form with "photos"
+		authorized.PUT("/incidents/:id", controllers.UpdateIncident)                         // Admin and security
+		authorized.DELETE("/incidents/:id", controllers.DeleteIncident)                      // Admin-only
+		authorized.PUT("/incidents/:id/status", controllers.UpdateIncidentStatus)            // Admin and security: open, in_progress, resolved
+		authorized.PUT("/incidents/:id/assignees", controllers.SetIncidentAssignees)         // Admin-only
+		authorized.POST("/incidents/:id/comments", controllers.AddIncidentComment)           // Admin and security
+		authorized.POST("/incidents/:id/photos", controllers.AddIncidentPhoto)               // Admin and security
+		authorized.DELETE("/incidents/:id/photos/:photoId", controllers.DeleteIncidentPhoto) // Admin or reporter
+
+		// SOS
+		authorized.POST("/sos", controllers.Idempotency, controllers.TriggerSOS) // Residents, "drill": true for admins and security only
+		authorized.GET("/sos", controllers.GetSOSAlerts)                         // Staff: ?status=&drill=, residents: own alerts
+		authorized.GET("/sos/summary", controllers.GetSOSSummary)                // Admin-only: ?month=&year=&drill=true
+		authorized.GET("/sos/:id", controllers.GetSOSById)                       // Staff, the resident
+		authorized.PUT("/sos/:id/acknowledge", controllers.AcknowledgeSOS)       // Admin and security
+		authorized.PUT("/sos/:id/resolve", controllers.ResolveSOS)               // Admin and security
+		authorized.PUT("/sos/:id/cancel", controllers.CancelSOS)                 // The resident
+
+		// Visitors
+		authorized.GET("/visitors", controllers.GetVisitors)                               // Staff: ?q=&host_id=&from=&to=&on_site=true, residents: own guests
+		authorized.POST("/visitors", controllers.Idempotency, controllers.RegisterVisitor) // Security-only: multipart form with "id_photo" and optional invite_token
+		authorized.PUT("/visitors/:id/check-out", controllers.CheckOutVisitor)             // Security-only
+		authorized.GET("/visitor-invites", controllers.GetVisitorInvites)                  // Residents: own invites, admins: all, ?active=true
+		authorized.POST("/visitor-invites", controllers.CreateVisitorInvite)               // Residents
+		authorized.GET("/visitor-invites/lookup", controllers.LookupVisitorInvite)         // Security-only: ?token= from the QR code
+		authorized.GET("/visitor-invites/:id/qr", controllers.GetVisitorInviteQR)          // The host: PNG to send to the guest
+		authorized.DELETE("/visitor-invites/:id", controllers.DeleteVisitorInvite)         // The host or admins
+
+		// Security shifts
+		authorized.GET("/shifts", controllers.GetShifts)                                    // Admin and security: ?from=&to=, security: own shifts
+		authorized.POST("/shifts", controllers.CreateShift)                                 // Admin-only
+		authorized.GET("/shifts/attendance", controllers.GetShiftAttendance)                // Admin-only: ?month=&year=
+		authorized.PUT("/shifts/:id", controllers.UpdateShift)                              // Admin-only
+		authorized.DELETE("/shifts/:id", controllers.DeleteShift)                           // Admin-only
+		authorized.POST("/shifts/:id/assignments", controllers.AssignShift)                 // Admin-only
+		authorized.DELETE("/shifts/:id/assignments/:securityId", controllers.UnassignShift) // Admin-only
+		authorized.POST("/shifts/:id/clock-in", controllers.ClockIn)                        // Security-only
+		authorized.POST("/shifts/:id/clock-out", controllers.ClockOut)                      // Security-only
+		authorized.GET("/shift-swaps", controllers.GetShiftSwaps)                           // Admin and security: ?status=
+		authorized.POST("/shift-swaps", controllers.CreateShiftSwap)                        // Security-only
+		authorized.PUT("/shift-swaps/:id/:action", controllers.UpdateShiftSwap)             // accept|decline (target), cancel (requester), approve|reject (admin)
+
+		// Event stream
+		authorized.POST("/events/ticket", controllers.CreateEventStreamTicket) // Single use ticket for GET /api/events?ticket=
 
 		// Logout
 		authorized.POST("/logout", controllers.Logout)
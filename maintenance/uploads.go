@@ -0,0 +1,164 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dontkeep/simaling-backend/storage"
+	"gorm.io/gorm"
+)
+
+// uploadReferences are the queries returning every storage key still in use, as a column named key.
+// Add a query here when a new table starts storing uploaded files, anything not listed is deleted.
+var uploadReferences = []string{
+	"SELECT funds_attachments.storage_key AS `key` FROM funds_attachments JOIN funds ON funds.id = funds_attachments.funds_id WHERE funds_attachments.deleted_at IS NULL AND funds.deleted_at IS NULL",
+	"SELECT funds_attachments.thumbnail AS `key` FROM funds_attachments JOIN funds ON funds.id = funds_attachments.funds_id WHERE funds_attachments.deleted_at IS NULL AND funds.deleted_at IS NULL",
+	"SELECT incident_photos.storage_key AS `key` FROM incident_photos JOIN incidents ON incidents.id = incident_photos.incident_id WHERE incident_photos.deleted_at IS NULL AND incidents.deleted_at IS NULL",
+	"SELECT incident_photos.thumbnail AS `key` FROM incident_photos JOIN incidents ON incidents.id = incident_photos.incident_id WHERE incident_photos.deleted_at IS NULL AND incidents.deleted_at IS NULL",
+	"SELECT visits.id_photo_key AS `key` FROM visits WHERE visits.deleted_at IS NULL",
+	"SELECT visits.id_photo_thumbnail AS `key` FROM visits WHERE visits.deleted_at IS NULL",
+	"SELECT security_record_photos.storage_key AS `key` FROM security_record_photos JOIN security_records ON security_records.id = security_record_photos.security_record_id WHERE security_record_photos.deleted_at IS NULL AND security_records.deleted_at IS NULL",
+	"SELECT security_record_photos.thumbnail AS `key` FROM security_record_photos JOIN security_records ON security_records.id = security_record_photos.security_record_id WHERE security_record_photos.deleted_at IS NULL AND security_records.deleted_at IS NULL",
+}
+
+// legacyUploadReferences cover columns dropped from the models but possibly still in the database.
+// Funds used to keep a single image (and thumbnail) on the row itself, the migration copies it into
+// funds_attachments but leaves the column in place, so those files stay referenced while it exists.
+var legacyUploadReferences = []struct {
+	table, column, query string
+}{
+	{"funds", "image", "SELECT funds.image AS `key` FROM funds WHERE funds.deleted_at IS NULL"},
+	{"funds", "thumbnail", "SELECT funds.thumbnail AS `key` FROM funds WHERE funds.deleted_at IS NULL"},
+}
+
+// UploadCleanupReport is the result of one CleanOrphanedUploads run
+type UploadCleanupReport struct {
+	Scanned     int              `json:"scanned"`
+	Referenced  int              `json:"referenced"`
+	Recent      int              `json:"recent"` // Orphans younger than the grace period, kept for now
+	Orphans     []storage.Object `json:"orphans"`
+	OrphanBytes int64            `json:"orphan_bytes"`
+	Deleted     int              `json:"deleted"`
+	Failed      int              `json:"failed"`
+	DryRun      bool             `json:"dry_run"`
+}
+
+// CleanOrphanedUploads compares the stored files with the keys referenced in the database and deletes
+// the files nothing points to anymore, such as the image of a deleted funds entry or an upload whose
+// database insert failed. Files modified less than grace ago are skipped because their row may not be
+// committed yet. With dryRun the orphans are only reported.
+func CleanOrphanedUploads(ctx context.Context, db *gorm.DB, store storage.Storage, grace time.Duration, dryRun bool) (*UploadCleanupReport, error) {
+	referenced, err := referencedUploads(db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UploadCleanupReport{DryRun: dryRun}
+	cutoff := time.Now().Add(-grace)
+	err = store.List(ctx, func(obj storage.Object) error {
+		report.Scanned++
+		if key, err := storage.CleanKey(obj.Key); err == nil && referenced[key] {
+			report.Referenced++
+			return nil
+		}
+		if obj.ModTime.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+		report.Orphans = append(report.Orphans, obj)
+		report.OrphanBytes += obj.Size
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	for _, obj := range report.Orphans {
+		if err := store.Delete(ctx, obj.Key); err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return report, err
+			}
+			log.Printf("Failed to delete orphaned upload %s: %v", obj.Key, err)
+			report.Failed++
+			continue
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+func referencedUploads(db *gorm.DB) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	queries := append([]string{}, uploadReferences...)
+	for _, legacy := range legacyUploadReferences {
+		if db.Migrator().HasColumn(legacy.table, legacy.column) {
+			queries = append(queries, legacy.query)
+		}
+	}
+	for _, query := range queries {
+		rows, err := db.Raw(query).Rows()
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key *string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if key == nil {
+				continue
+			}
+			// Normalize the same way the storage backends do, old rows may still use backslashes
+			if cleaned, err := storage.CleanKey(*key); err == nil {
+				referenced[cleaned] = true
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return referenced, nil
+}
+
+// ScheduleUploadCleanup runs CleanOrphanedUploads every interval until ctx is cancelled.
+// It is meant to be started in its own goroutine.
+func ScheduleUploadCleanup(ctx context.Context, db *gorm.DB, store storage.Storage, interval, grace time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := CleanOrphanedUploads(ctx, db, store, grace, dryRun)
+		if err != nil {
+			log.Printf("Upload cleanup failed: %v", err)
+			continue
+		}
+		LogUploadCleanupReport(report)
+	}
+}
+
+// LogUploadCleanupReport prints a summary of a cleanup run and the orphans it found
+func LogUploadCleanupReport(report *UploadCleanupReport) {
+	for _, obj := range report.Orphans {
+		log.Printf("Orphaned upload %s (%d bytes, modified %s)", obj.Key, obj.Size, obj.ModTime.Format(time.RFC3339))
+	}
+	if report.DryRun {
+		log.Printf("Upload cleanup (dry run): %d files scanned, %d referenced, %d too recent, %d orphaned (%d bytes)",
+			report.Scanned, report.Referenced, report.Recent, len(report.Orphans), report.OrphanBytes)
+		return
+	}
+	log.Printf("Upload cleanup: %d files scanned, %d referenced, %d too recent, %d orphaned (%d bytes), %d deleted, %d failed",
+		report.Scanned, report.Referenced, report.Recent, len(report.Orphans), report.OrphanBytes, report.Deleted, report.Failed)
+}
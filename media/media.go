@@ -0,0 +1,155 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+var (
+	// ErrTooLarge is returned when the upload is bigger than the allowed size
+	ErrTooLarge = errors.New("file is too large")
+	// ErrUnsupportedType is returned when the content is not a JPEG, PNG, WebP or PDF
+	ErrUnsupportedType = errors.New("unsupported file type, only JPEG, PNG, WebP and PDF are allowed")
+	// ErrInvalidImage is returned when the content claims to be an image but cannot be decoded
+	ErrInvalidImage = errors.New("invalid or corrupted image")
+)
+
+const (
+	// ThumbnailSize is the longest side of generated thumbnails, in pixels
+	ThumbnailSize = 320
+	// maxPixels guards against decompression bombs (about 8000x5000)
+	maxPixels = 40_000_000
+)
+
+// Processed is an upload that passed validation and is ready to be stored
+type Processed struct {
+	Data        []byte
+	ContentType string
+	Ext         string
+	// Thumbnail is a small JPEG preview, nil for PDFs
+	Thumbnail []byte
+}
+
+// Process validates an upload by its content (the client's extension and content type are ignored).
+// Images are decoded and re-encoded, which drops EXIF data such as GPS coordinates,
+// after the EXIF orientation has been applied to the pixels. WebP is converted to JPEG.
+// PDFs are checked and stored as they are.
+func Process(r io.Reader, maxSize int64) (*Processed, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		img, err := decode(data, jpeg.DecodeConfig, jpeg.Decode)
+		if err != nil {
+			return nil, err
+		}
+		img = applyOrientation(img, exifOrientation(data))
+		return encodeJPEG(img)
+	case "image/png":
+		img, err := decode(data, png.DecodeConfig, png.Decode)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		thumb, err := thumbnail(img)
+		if err != nil {
+			return nil, err
+		}
+		return &Processed{Data: buf.Bytes(), ContentType: "image/png", Ext: ".png", Thumbnail: thumb}, nil
+	case "image/webp":
+		img, err := decode(data, webp.DecodeConfig, webp.Decode)
+		if err != nil {
+			return nil, err
+		}
+		return encodeJPEG(img)
+	case "application/pdf":
+		return &Processed{Data: data, ContentType: "application/pdf", Ext: ".pdf"}, nil
+	}
+	return nil, ErrUnsupportedType
+}
+
+func decode(data []byte, decodeConfig func(io.Reader) (image.Config, error), decodeImage func(io.Reader) (image.Image, error)) (image.Image, error) {
+	cfg, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrInvalidImage
+	}
+	img, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return img, nil
+}
+
+func encodeJPEG(img image.Image) (*Processed, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	thumb, err := thumbnail(img)
+	if err != nil {
+		return nil, err
+	}
+	return &Processed{Data: buf.Bytes(), ContentType: "image/jpeg", Ext: ".jpg", Thumbnail: thumb}, nil
+}
+
+// flatten draws the image on a white background, JPEG has no transparency
+func flatten(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// thumbnail scales the image down so its longest side is ThumbnailSize and encodes it as JPEG
+func thumbnail(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > ThumbnailSize || h > ThumbnailSize {
+		if w >= h {
+			h = h * ThumbnailSize / w
+			w = ThumbnailSize
+		} else {
+			w = w * ThumbnailSize / h
+			h = ThumbnailSize
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
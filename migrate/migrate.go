@@ -1,6 +1,8 @@
 package main
 
 import (
+	"log"
+
 	"github.com/dontkeep/simaling-backend/initializers"
 	"github.com/dontkeep/simaling-backend/models"
 )
@@ -11,5 +13,46 @@ func init() {
 }
 
 func main() {
-	initializers.DB.AutoMigrate(&models.User{}, &models.Roles{}, &models.Funds{}, &models.BlacklistToken{}, &models.SecurityRecord{})
+	// Security record coordinates used to be "%f" strings, convert the columns to numbers before AutoMigrate
+	// indexes them. Values that are not numbers or out of range are cleared, the record keeps its other data.
+	var coordinateType string
+	initializers.DB.Raw("SELECT DATA_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'security_records' AND COLUMN_NAME = 'latitude'").Scan(&coordinateType)
+	if coordinateType != "" && coordinateType != "double" {
+		number := "'^[-+]?[0-9]+([.][0-9]+)?$'"
+		for _, query := range []string{
+			"UPDATE security_records SET latitude = NULL, longitude = NULL WHERE latitude IS NULL OR longitude IS NULL OR TRIM(latitude) NOT REGEXP " + number + " OR TRIM(longitude) NOT REGEXP " + number,
+			"UPDATE security_records SET latitude = TRIM(latitude), longitude = TRIM(longitude) WHERE latitude IS NOT NULL",
+			"UPDATE security_records SET latitude = NULL, longitude = NULL WHERE latitude IS NOT NULL AND (CAST(latitude AS DECIMAL(20,10)) NOT BETWEEN -90 AND 90 OR CAST(longitude AS DECIMAL(20,10)) NOT BETWEEN -180 AND 180)",
+			"ALTER TABLE security_records MODIFY latitude DOUBLE NULL, MODIFY longitude DOUBLE NULL",
+		} {
+			if err := initializers.DB.Exec(query).Error; err != nil {
+				log.Fatalf("convert security record coordinates: %v", err)
+			}
+		}
+	}
+
+	initializers.DB.AutoMigrate(&models.User{}, &models.Roles{}, &models.Funds{}, &models.BlacklistToken{}, &models.SecurityRecord{}, &models.IdempotencyKey{}, &models.FundsAttachment{}, &models.Checkpoint{}, &models.PatrolRoute{}, &models.PatrolRouteStop{}, &models.Shift{}, &models.ShiftAssignment{}, &models.ShiftSwapRequest{}, &models.PatrolAlert{}, &models.Incident{}, &models.IncidentAssignee{}, &models.IncidentComment{}, &models.IncidentPhoto{}, &models.SOSAlert{}, &models.SOSAcknowledgement{}, &models.VisitorInvite{}, &models.Visit{}, &models.SecurityRecordPhoto{}, &models.DeprecatedRouteUsage{}, &models.EventStreamTicket{})
+
+	// The first security record endpoint kept the phone number of the visited resident
+	if err := initializers.DB.Exec("UPDATE security_records JOIN users ON users.phone_no = security_records.phone_no AND users.role_id = 2 " +
+		"SET security_records.purpose = 'resident_visit', security_records.resident_id = users.id " +
+		"WHERE security_records.resident_id IS NULL AND security_records.phone_no <> ''").Error; err != nil {
+		log.Fatalf("backfill security record purpose: %v", err)
+	}
+
+	// Funds used to have a single image column, move it into funds_attachments.
+	// The old columns are kept so the data is not lost if the migration has to be redone.
+	if initializers.DB.Migrator().HasColumn("funds", "image") {
+		// Funds.Image used to hold the local path ("uploads/123.jpg"), it now holds the storage key ("123.jpg")
+		initializers.DB.Exec("UPDATE funds SET image = REPLACE(SUBSTRING(image, 9), CHAR(92), '/') WHERE LEFT(image, 8) IN ('uploads/', CONCAT('uploads', CHAR(92)))")
+
+		thumbnail := "''"
+		if initializers.DB.Migrator().HasColumn("funds", "thumbnail") {
+			thumbnail = "COALESCE(funds.thumbnail, '')"
+		}
+		initializers.DB.Exec("INSERT INTO funds_attachments (created_at, updated_at, funds_id, type, caption, position, storage_key, thumbnail) " +
+			"SELECT funds.created_at, funds.created_at, funds.id, IF(funds.is_income, 'transfer_proof', 'receipt'), '', 0, funds.image, " + thumbnail + " " +
+			"FROM funds WHERE funds.image IS NOT NULL AND funds.image <> '' " +
+			"AND NOT EXISTS (SELECT 1 FROM funds_attachments WHERE funds_attachments.funds_id = funds.id)")
+	}
 }
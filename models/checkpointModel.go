@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+type Checkpoint struct {
+	gorm.Model
+	Name        string
+	Block       string
+	Latitude    float64
+	Longitude   float64
+	Radius      float64
+	Is_Active   bool
+	Token_Nonce string
+}
+
+// Checkpoint is a place security guards have to visit on patrol, for example a block gate or a guard post.
+// Latitude and Longitude are in degrees (WGS84), Radius is the size of the geofence in meters.
+// Inactive checkpoints are kept for the history of existing security records but are no longer matched.
+// Token_Nonce is part of the signed token printed in the QR code (or written to the NFC tag) of the checkpoint,
+// changing it invalidates the printed codes.
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DeprecatedRouteUsage struct {
+	gorm.Model
+	Route         string `gorm:"size:100;uniqueIndex:idx_deprecated_route_usage"`
+	User_Id       uint   `gorm:"uniqueIndex:idx_deprecated_route_usage"`
+	Client        string `gorm:"size:150;uniqueIndex:idx_deprecated_route_usage"`
+	Calls         int64
+	First_Used_At time.Time
+	Last_Used_At  time.Time `gorm:"index"`
+}
+
+// DeprecatedRouteUsage counts the calls to a deprecated route per user and client, so we know when the
+// mobile apps have moved to the successor. Client is the X-App-Version header, or the User-Agent when
+// the app does not send it.
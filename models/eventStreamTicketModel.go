@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EventStreamTicket struct {
+	gorm.Model
+	Ticket     string `gorm:"size:64;uniqueIndex"`
+	User_Id    uint
+	Token      string    `gorm:"type:text"`
+	Expires_At time.Time `gorm:"index"`
+}
+
+// EventStreamTicket lets an EventSource client, which cannot set headers, open the event stream without
+// putting its JWT in the URL. The ticket is random, used once and only valid until Expires_At,
+// Token is the JWT it was created with so the stream still ends when that token is logged out.
@@ -0,0 +1,19 @@
+package models
+
+import "gorm.io/gorm"
+
+type FundsAttachment struct {
+	gorm.Model
+	Funds       Funds `gorm:"foreignKey:Funds_Id"`
+	Funds_Id    uint  `gorm:"index"`
+	Type        string
+	Caption     string
+	Position    int
+	Storage_Key string
+	Thumbnail   string
+}
+
+// FundsAttachment is a file attached to a funds entry (store receipt, proof of transfer, photo, ...).
+// Type is one of "receipt", "transfer_proof", "invoice", "photo" or "other".
+// Position orders the attachments of an entry, the first one is used as the image of the entry in listings.
+// Storage_Key and Thumbnail are keys in the storage backend, Thumbnail is empty for PDFs.
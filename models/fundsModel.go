@@ -9,8 +9,8 @@ type Funds struct {
 	User_Id     uint
 	Block       string
 	Amount      float64
-	Image       string
 	Description string
+	Category    string
 	Is_Income   bool
 	Status      string
 }
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type IdempotencyKey struct {
+	gorm.Model
+	Key          string `gorm:"size:255;uniqueIndex:idx_idempotency_user_key"`
+	User_Id      uint   `gorm:"uniqueIndex:idx_idempotency_user_key"`
+	Method       string
+	Path         string
+	Request_Hash string
+	Status_Code  int
+	Content_Type string
+	Response     string    `gorm:"type:mediumtext"`
+	Expires_At   time.Time `gorm:"index"`
+}
+
+// IdempotencyKey stores the response of a POST request sent with an Idempotency-Key header.
+// Status_Code is 0 while the first request is still being processed.
+// Request_Hash is used to reject a key that is reused with a different request body.
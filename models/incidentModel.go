@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Incident struct {
+	gorm.Model
+	Reporter             User   `gorm:"foreignKey:Reporter_Id"`
+	Reporter_Id          uint   `gorm:"index"`
+	Type                 string `gorm:"index"`
+	Severity             string `gorm:"index"`
+	Title                string
+	Description          string `gorm:"type:text"`
+	Block                string `gorm:"index"`
+	Latitude             *float64
+	Longitude            *float64
+	Status               string `gorm:"index"`
+	Visible_To_Residents bool
+	Resolved_At          *time.Time
+	Assignees            []IncidentAssignee `gorm:"foreignKey:Incident_Id"`
+	Photos               []IncidentPhoto    `gorm:"foreignKey:Incident_Id"`
+}
+
+// Incident is something security staff found on patrol, for example an open gate or a fire hazard.
+// Type is one of "open_gate", "suspicious_person", "fire_hazard", "vandalism" or "other",
+// Severity one of "low", "medium", "high" or "critical".
+// Status goes from "open" to "in_progress" and "resolved", a resolved incident can be reopened.
+// Residents of Block see the incident when Visible_To_Residents is set.
+
+type IncidentAssignee struct {
+	gorm.Model
+	Incident_Id uint `gorm:"uniqueIndex:idx_incident_assignee"`
+	User        User `gorm:"foreignKey:User_Id"`
+	User_Id     uint `gorm:"uniqueIndex:idx_incident_assignee"`
+}
+
+// IncidentAssignee is an admin or guard handling an incident
+
+type IncidentComment struct {
+	gorm.Model
+	Incident_Id uint `gorm:"index"`
+	User        User `gorm:"foreignKey:User_Id"`
+	User_Id     uint
+	Body        string `gorm:"type:text"`
+}
+
+// IncidentComment is a note of the staff on an incident, status changes are logged as comments too
+
+type IncidentPhoto struct {
+	gorm.Model
+	Incident_Id uint `gorm:"index"`
+	Storage_Key string
+	Thumbnail   string
+}
+
+// IncidentPhoto is a photo of an incident, Storage_Key and Thumbnail are keys in the storage backend
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PatrolAlert struct {
+	gorm.Model
+	Type            string `gorm:"index"`
+	Dedup_Key       string `gorm:"size:191;uniqueIndex"`
+	Security        User   `gorm:"foreignKey:Security_Id"`
+	Security_Id     *uint  `gorm:"index"`
+	Shift_Id        *uint
+	Route_Id        *uint
+	Round           int
+	Message         string
+	Status          string `gorm:"index"`
+	Escalated_At    *time.Time
+	Acknowledged_At *time.Time
+	Acknowledged_By *uint
+}
+
+// PatrolAlert is raised by the patrol monitor. Type is "inactivity" (Security_Id sent no security record
+// during Shift_Id for too long) or "missed_patrol" (a round of Route_Id was not completed).
+// Dedup_Key makes sure the monitor raises the same alert only once.
+// Status goes from "Open" to "Acknowledged" and "Resolved", open alerts are escalated to the RT head after a while.
@@ -0,0 +1,32 @@
+package models
+
+import "gorm.io/gorm"
+
+type PatrolRoute struct {
+	gorm.Model
+	Name             string
+	Start_Time       string
+	End_Time         string
+	Interval_Minutes int
+	Grace_Minutes    int
+	Is_Active        bool
+	Stops            []PatrolRouteStop `gorm:"foreignKey:Route_Id"`
+}
+
+// PatrolRoute is an ordered list of stops guards have to visit on every round of the night.
+// Start_Time and End_Time are "HH:MM" in Jakarta time, an end before the start means the next morning
+// (22:00 to 04:00). A round starts every Interval_Minutes, a stop visited more than Grace_Minutes after
+// the start of its round counts as late.
+
+type PatrolRouteStop struct {
+	gorm.Model
+	Route_Id      uint `gorm:"index"`
+	Position      int
+	Checkpoint    Checkpoint `gorm:"foreignKey:Checkpoint_Id"`
+	Checkpoint_Id *uint
+	Block         string
+}
+
+// PatrolRouteStop is one stop of a route, either a checkpoint or, when Checkpoint_Id is nil, a block.
+// A checkpoint stop is visited by a security record matched to that checkpoint, a block stop by any
+// security record for the block.
@@ -1,13 +1,66 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type SecurityRecord struct {
 	gorm.Model
-	Security_ID User `gorm:"foreignKey:Security_Id"`
-	Security_Id uint
-	Block       string
-	Phone_No    string
-	Longitude   string
-	Latitude    string
+	Security_ID      User `gorm:"foreignKey:Security_Id"`
+	Security_Id      uint
+	Block            string
+	Phone_No         string
+	Longitude        *float64   `gorm:"index:idx_security_records_location,priority:2"`
+	Latitude         *float64   `gorm:"index:idx_security_records_location,priority:1"`
+	Checkpoint       Checkpoint `gorm:"foreignKey:Checkpoint_Id"`
+	Checkpoint_Id    *uint      `gorm:"index"`
+	Distance         float64
+	Accuracy         float64
+	Outside_Geofence bool
+	Low_Accuracy     bool
+	Outside_Shift    bool
+	Method           string `gorm:"default:gps"`
+	Scanned_At       *time.Time
+	Scan_Nonce       *string `gorm:"size:64;uniqueIndex"`
+	Client_Id        *string `gorm:"size:36;uniqueIndex"`
+	Captured_At      *time.Time
+	Received_At      *time.Time
+	Late_Sync        bool
+	Sync_Reviewed_By *uint
+	Sync_Reviewed_At *time.Time
+	Purpose          string `gorm:"size:20;default:patrol"`
+	Resident         User   `gorm:"foreignKey:Resident_Id"`
+	Resident_Id      *uint  `gorm:"index"`
+}
+
+// Latitude and Longitude are in decimal degrees, both are nil for a scanned record sent without coordinates.
+// Checkpoint_Id is the nearest active checkpoint when the record was created (nil when none is defined)
+// and Distance the distance to it in meters. Accuracy is the GPS accuracy in meters reported by the
+// phone, 0 when the client did not send it.
+// Outside_Geofence is set when the record is further from the checkpoint than its radius,
+// Low_Accuracy when the reported accuracy is worse than GPS_MAX_ACCURACY_METERS
+// and Outside_Shift when the guard was not assigned to a running shift.
+// Method is "gps" for records sent with coordinates only and "qr" for records created by scanning the code of
+// a checkpoint. Scanned records keep the time of the scan (Scanned_At) and the client generated Scan_Nonce
+// that prevents the same scan from being submitted twice.
+// Purpose is "patrol" for a round of the complex and "resident_visit" for a visit to the house of Resident_Id,
+// those records keep the phone number of the resident in Phone_No instead of the guard's.
+// Records uploaded later by the offline sync keep the client generated UUID (Client_Id), the time the
+// phone captured them (Captured_At) and the time the server received them (Received_At, nil for the others).
+// Late_Sync is set when they arrived longer after the capture than an online scan may be old, those records
+// do not count for the patrol schedule until an admin reviewed them (Sync_Reviewed_By and Sync_Reviewed_At).
+
+type SecurityRecordPhoto struct {
+	gorm.Model
+	Security_Record_Id *uint `gorm:"index"`
+	Uploaded_By        uint  `gorm:"index"`
+	Storage_Key        string
+	Thumbnail          string
+	Caption            string
 }
+
+// SecurityRecordPhoto is photo evidence of a security record, Storage_Key and Thumbnail are keys in the storage backend.
+// A photo uploaded ahead of its record has no Security_Record_Id until the record lists it in photo_ids,
+// the cleanup job removes the file once it is older than the upload grace period.
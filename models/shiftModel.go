@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Shift struct {
+	gorm.Model
+	Name        string
+	Start_At    time.Time `gorm:"index"`
+	End_At      time.Time `gorm:"index"`
+	Notes       string
+	Assignments []ShiftAssignment `gorm:"foreignKey:Shift_Id"`
+}
+
+// Shift is a period a number of security guards are on duty, for example the night of 1 June from 22:00 to 06:00.
+
+type ShiftAssignment struct {
+	gorm.Model
+	Shift               Shift `gorm:"foreignKey:Shift_Id"`
+	Shift_Id            uint  `gorm:"uniqueIndex:idx_shift_assignment"`
+	Security            User  `gorm:"foreignKey:Security_Id"`
+	Security_Id         uint  `gorm:"uniqueIndex:idx_shift_assignment"`
+	Clock_In_At         *time.Time
+	Clock_In_Latitude   *float64
+	Clock_In_Longitude  *float64
+	Clock_Out_At        *time.Time
+	Clock_Out_Latitude  *float64
+	Clock_Out_Longitude *float64
+}
+
+// ShiftAssignment puts a guard on a shift and holds the attendance: when and where the guard clocked in and out.
+
+type ShiftSwapRequest struct {
+	gorm.Model
+	Assignment    ShiftAssignment `gorm:"foreignKey:Assignment_Id"`
+	Assignment_Id uint            `gorm:"index"`
+	Requester_Id  uint
+	Target_Id     uint
+	Reason        string
+	Status        string
+	Decided_By    *uint
+}
+
+// ShiftSwapRequest asks another guard (Target_Id) to take over the assignment of Requester_Id.
+// Status goes from "Pending" to "Accepted" or "Declined" by the target guard, then an admin sets "Approved"
+// (the assignment moves to the target guard) or "Rejected". The requester can set "Cancelled" before that.
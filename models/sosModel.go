@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SOSAlert struct {
+	gorm.Model
+	Resident         User   `gorm:"foreignKey:Resident_Id"`
+	Resident_Id      uint   `gorm:"index"`
+	Category         string `gorm:"index"`
+	Message          string
+	Block            string
+	Latitude         float64
+	Longitude        float64
+	Accuracy         float64
+	Status           string `gorm:"index"`
+	Is_Drill         bool   `gorm:"index"`
+	Acknowledged_At  *time.Time
+	Response_Seconds *int
+	Resolved_At      *time.Time
+	Resolved_By      *uint
+	Resolution       string
+	Acknowledgements []SOSAcknowledgement `gorm:"foreignKey:SOS_Id"`
+}
+
+// SOSAlert is a call for help sent by a resident. Category is "medical", "theft", "fire" or "other".
+// Status goes from "active" to "acknowledged" when the first guard or admin responds, then "resolved";
+// the resident can set "cancelled" (false alarm) before it is resolved.
+// Response_Seconds is the time between the alert and the first acknowledgement.
+// Drills (Is_Drill) go through the same dispatch but are labelled as such and left out of the statistics.
+
+type SOSAcknowledgement struct {
+	gorm.Model
+	SOS_Id    uint `gorm:"uniqueIndex:idx_sos_acknowledgement"`
+	User      User `gorm:"foreignKey:User_Id"`
+	User_Id   uint `gorm:"uniqueIndex:idx_sos_acknowledgement"`
+	Latitude  *float64
+	Longitude *float64
+}
+
+// SOSAcknowledgement records that a guard or admin is responding to an SOS, with where they were at that moment
@@ -10,7 +10,8 @@ type User struct {
 	Password string
 	Name     string
 	Address  string
-	Role     Roles `gorm:"foreignKey:Role_Id"`
+	Block    string `gorm:"index"`
+	Role     Roles  `gorm:"foreignKey:Role_Id"`
 	Role_Id  uint
 }
 
@@ -20,6 +21,7 @@ type User struct {
 // NIK: the national identification number of the user.
 // Name: the name of the user.
 // Address: the address of the user.
+// Block: the block of the housing complex the user lives in, used to show residents the incidents of their block.
 // Funds: the funds of the user.
 // Role: the role of the user. It is a foreign key that references the Role_Id field in the roles table.
 // Role_Id: the foreign key that references the Role_Id field in the roles table.
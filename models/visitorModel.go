@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type VisitorInvite struct {
+	gorm.Model
+	Host          User `gorm:"foreignKey:Host_Id"`
+	Host_Id       uint `gorm:"index"`
+	Guest_Name    string
+	Vehicle_Plate string
+	Purpose       string
+	Valid_From    time.Time
+	Valid_Until   time.Time
+	Token         string `gorm:"size:64;uniqueIndex"`
+	Used_At       *time.Time
+}
+
+// VisitorInvite is a guest a resident expects. The QR code of the invite carries Token,
+// it can be used once between Valid_From and Valid_Until to check the guest in without calling the host.
+
+type Visit struct {
+	gorm.Model
+	Guard              User `gorm:"foreignKey:Guard_Id"`
+	Guard_Id           uint
+	Host               User  `gorm:"foreignKey:Host_Id"`
+	Host_Id            *uint `gorm:"index"`
+	Destination        string
+	Guest_Name         string `gorm:"index"`
+	Vehicle_Plate      string `gorm:"index"`
+	Purpose            string
+	Id_Photo_Key       string
+	Id_Photo_Thumbnail string
+	Invite_Id          *uint
+	Check_In_At        time.Time `gorm:"index"`
+	Check_Out_At       *time.Time
+	Check_Out_By       *uint
+}
+
+// Visit is a guest registered at the gate by Guard_Id. Destination is the house visited,
+// Host_Id the resident living there when known. Id_Photo_Key and Id_Photo_Thumbnail are keys in the storage backend.
+// Invite_Id is set when the guest came with a QR invite, the visit was then pre-approved by the host.
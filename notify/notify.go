@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Recipient is a person a notification is meant for. Channels use the fields they understand,
+// for example a WhatsApp gateway behind the webhook needs the phone number.
+type Recipient struct {
+	UserID uint   `json:"user_id,omitempty"`
+	Name   string `json:"name"`
+	Phone  string `json:"phone,omitempty"`
+}
+
+// Message is a notification sent to one or more recipients
+type Message struct {
+	Event      string                 `json:"event"` // Kind of notification, such as "patrol_alert"
+	Title      string                 `json:"title"`
+	Body       string                 `json:"body"`
+	Recipients []Recipient            `json:"recipients"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// Channel delivers messages, for example by logging them or by calling a webhook
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Dispatcher sends every message to all configured channels
+type Dispatcher struct {
+	channels []Channel
+}
+
+func NewDispatcher(channels ...Channel) *Dispatcher {
+	return &Dispatcher{channels: channels}
+}
+
+// Send delivers the message through every channel. A failing channel does not stop the others,
+// the returned error joins the failures.
+func (d *Dispatcher) Send(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, ch := range d.channels {
+		if err := ch.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send %s notification through %s: %v", msg.Event, ch.Name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", ch.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Log writes messages to the application log, it is always enabled so notifications are never lost silently
+type Log struct{}
+
+func (Log) Name() string { return "log" }
+
+func (Log) Send(_ context.Context, msg Message) error {
+	names := make([]string, len(msg.Recipients))
+	for i, r := range msg.Recipients {
+		names[i] = r.Name
+	}
+	log.Printf("[%s] %s: %s (to %v)", msg.Event, msg.Title, msg.Body, names)
+	return nil
+}
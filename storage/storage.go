@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Open when no object is stored under the key
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrInvalidKey is returned for keys that are empty or try to escape the storage root
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Storage stores uploaded files by key. Keys are slash separated relative paths
+// such as "funds/1748678580860881700.jpg", the database only keeps the key.
+type Storage interface {
+	// Put stores the content of r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the content stored under key, or ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients use to download the object
+	URL(key string) string
+	// List calls fn for every stored object, stopping at the first error returned by fn
+	List(ctx context.Context, fn func(Object) error) error
+}
+
+// Object describes a stored file as returned by List
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// CleanKey normalizes a key and rejects keys that could escape the storage root.
+// Backslashes are accepted because images uploaded from Windows hosts were saved with them.
+func CleanKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}